@@ -0,0 +1,138 @@
+package bertrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// ============================================================================
+// Arbitrary precision integers (big.Int) and fractions (big.Rat)
+//
+// A big.Int is encoded as the smallest Erlang integer that can hold it: SMALL_INTEGER_EXT, INTEGER_EXT,
+// SMALL_BIG_EXT or LARGE_BIG_EXT for magnitudes over 255 bytes.
+//
+// Erlang has no fractions: a big.Rat is encoded as a {Numerator, Denominator} tuple of integers.
+// The denominator is always positive, and the fraction is in lowest terms.
+
+func encodeBigInt(buf termWriter, n *big.Int) error {
+	if n.IsInt64() {
+		return encodeInt(buf, n.Int64())
+	}
+
+	// Magnitude, as little-endian digits
+	digits := n.Bytes()
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	var sign byte
+	if n.Sign() < 0 {
+		sign = 1
+	}
+
+	if len(digits) <= 255 {
+		buf.WriteByte(TagBigInteger)
+		buf.WriteByte(byte(len(digits)))
+	} else {
+		buf.WriteByte(TagLargeBigInteger)
+		if err := binary.Write(buf, binary.BigEndian, uint32(len(digits))); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(sign)
+	buf.Write(digits)
+	return nil
+}
+
+func encodeBigRat(buf termWriter, q *big.Rat) error {
+	buf.WriteByte(TagSmallTuple)
+	buf.WriteByte(2)
+	if err := encodeBigInt(buf, q.Num()); err != nil {
+		return err
+	}
+	return encodeBigInt(buf, q.Denom())
+}
+
+func decodeBigInt(r io.Reader) (*big.Int, error) {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBigIntBody(r, int(byte1[0]))
+}
+
+// decodeBigIntBody decodes an integer of any size whose tag has already been read.
+func decodeBigIntBody(r io.Reader, tag int) (*big.Int, error) {
+	var length int
+	switch tag {
+	case TagSmallInteger, TagInteger:
+		i, err := decodeIntBody(r, tag)
+		if err != nil {
+			return nil, err
+		}
+		return big.NewInt(i), nil
+	case TagBigInteger:
+		byte1 := make([]byte, 1)
+		if err := readFull(r, byte1); err != nil {
+			return nil, err
+		}
+		length = int(byte1[0])
+	case TagLargeBigInteger:
+		byte4 := make([]byte, 4)
+		if err := readFull(r, byte4); err != nil {
+			return nil, err
+		}
+		length = int(binary.BigEndian.Uint32(byte4))
+	default:
+		return nil, TagError{Expected: TagSmallInteger, Got: tag}
+	}
+
+	// Sign, then magnitude as little-endian digits
+	data, err := readBytes(r, 1+length)
+	if err != nil {
+		return nil, err
+	}
+	sign, digits := data[0], data[1:]
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	n := new(big.Int).SetBytes(digits)
+	if sign != 0 {
+		n.Neg(n)
+	}
+	// A big integer must not fit in an INTEGER_EXT, nor have unneeded leading zero digits
+	if optionsOf(r).canonicalIntegers && (n.IsInt64() && n.Int64() >= math.MinInt32 && n.Int64() <= math.MaxInt32 ||
+		length == 0 || digits[0] == 0 || tag == TagLargeBigInteger && length <= math.MaxUint8) {
+		return nil, ErrNotCanonical
+	}
+	return n, nil
+}
+
+func decodeBigRat(r io.Reader, val reflect.Value) error {
+	length, err := readTupleInfo(r)
+	if err != nil {
+		return err
+	}
+	if length != 2 {
+		return errors.New("fraction should be a {Numerator, Denominator} tuple")
+	}
+
+	num, err := decodeBigInt(r)
+	if err != nil {
+		return err
+	}
+	denom, err := decodeBigInt(r)
+	if err != nil {
+		return err
+	}
+	if denom.Sign() == 0 {
+		return errors.New("fraction denominator cannot be zero")
+	}
+	val.Set(reflect.ValueOf(new(big.Rat).SetFrac(num, denom)).Elem())
+	return nil
+}
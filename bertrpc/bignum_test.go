@@ -0,0 +1,147 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestBigRat(t *testing.T) {
+	third := big.NewRat(1, 3)
+
+	data, err := bertrpc.Encode(third)
+	if err != nil {
+		t.Error(err)
+	}
+	// {1, 3}
+	expected := []byte{131, 104, 2, 97, 1, 97, 3}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeBigRat: expected %v, actual %v", expected, data)
+	}
+
+	var decoded big.Rat
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if decoded.Cmp(third) != 0 {
+		t.Errorf("incorrect decoded value: %s (!= %s)", decoded.String(), third)
+	}
+}
+
+// Fractions with numerators and denominators that do not fit in an int64
+func TestBigRatLarge(t *testing.T) {
+	num, _ := new(big.Int).SetString("-1267650600228229401496703205376", 10) // -2^100
+	denom, _ := new(big.Int).SetString("36893488147419103231", 10)           // 2^65 - 1
+	q := new(big.Rat).SetFrac(num, denom)
+
+	data, err := bertrpc.Encode(bertrpc.T(bertrpc.A("ratio"), q))
+	if err != nil {
+		t.Error(err)
+	}
+
+	var decoded struct {
+		Name  string
+		Value *big.Rat
+	}
+	decoded.Value = new(big.Rat)
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if decoded.Value.Cmp(q) != 0 {
+		t.Errorf("incorrect decoded value: %s (!= %s)", decoded.Value, q)
+	}
+}
+
+func TestDecodeBigInt(t *testing.T) {
+	twoTo64, _ := new(big.Int).SetString("18446744073709551616", 10)
+	large := new(big.Int).Lsh(big.NewInt(1), 8*256) // 2^2048 does not fit in SMALL_BIG_EXT
+	tests := []struct {
+		name  string
+		input []byte
+		want  *big.Int
+	}{
+		{name: "small integer", input: []byte{131, 97, 42}, want: big.NewInt(42)},
+		{name: "integer", input: []byte{131, 98, 255, 255, 255, 255}, want: big.NewInt(-1)},
+		{name: "2^64", input: []byte{131, 110, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, want: twoTo64},
+		{name: "-2^64", input: []byte{131, 110, 9, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1}, want: new(big.Int).Neg(twoTo64)},
+		{name: "2^2048", input: append(append([]byte{131, 111, 0, 0, 1, 1, 0}, make([]byte, 256)...), 1), want: large},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			// Decode into a *big.Int variable
+			var n *big.Int
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &n); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if n == nil || n.Cmp(tc.want) != 0 {
+				st.Errorf("incorrect decoded value: %v (!= %v)", n, tc.want)
+			}
+
+			// Decode into a big.Int
+			var v big.Int
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &v); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if v.Cmp(tc.want) != 0 {
+				st.Errorf("incorrect decoded value: %v (!= %v)", &v, tc.want)
+			}
+		})
+	}
+}
+
+// Expected bytes are the output of term_to_binary in an Erlang shell.
+func TestEncodeBigInt(t *testing.T) {
+	twoTo100 := new(big.Int).Lsh(big.NewInt(1), 100)
+	large := new(big.Int).Lsh(big.NewInt(1), 8*256)
+	tests := []struct {
+		name     string
+		n        *big.Int
+		expected []byte
+	}{
+		// term_to_binary(42)
+		{name: "42", n: big.NewInt(42), expected: []byte{131, 97, 42}},
+		// term_to_binary(1 bsl 100)
+		{name: "2^100", n: twoTo100,
+			expected: []byte{131, 110, 13, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 16}},
+		// term_to_binary(-(1 bsl 100))
+		{name: "-2^100", n: new(big.Int).Neg(twoTo100),
+			expected: []byte{131, 110, 13, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 16}},
+		// term_to_binary(1 bsl 2048): 257 bytes do not fit in SMALL_BIG_EXT
+		{name: "2^2048", n: large, expected: append(append([]byte{131, 111, 0, 0, 1, 1, 0}, make([]byte, 256)...), 1)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			data, err := bertrpc.Encode(tc.n)
+			if err != nil {
+				st.Error(err)
+			}
+			if !bytes.Equal(data, tc.expected) {
+				st.Errorf("EncodeBigInt: expected %v, actual %v", tc.expected, data)
+			}
+
+			// big.Int values are encoded like pointers
+			data, err = bertrpc.Encode(*tc.n)
+			if err != nil {
+				st.Error(err)
+			}
+			if !bytes.Equal(data, tc.expected) {
+				st.Errorf("EncodeBigInt: expected %v, actual %v", tc.expected, data)
+			}
+
+			var n *big.Int
+			if err := bertrpc.Decode(bytes.NewBuffer(data), &n); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+			} else if n.Cmp(tc.n) != 0 {
+				st.Errorf("incorrect decoded value: %v (!= %v)", n, tc.n)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package bertrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// ErrNotCanonical is returned by VerifyCanonical when a term is not in canonical form.
+var ErrNotCanonical = errors.New("term is not in canonical form")
+
+// CanonicalMarshal encodes a term in a canonical form: two terms that are equal in Erlang are always
+// encoded to the same bytes. The result can be used to sign or hash terms exchanged with Erlang.
+//
+// The canonical form is the one used by Encode, except for lists of small integers:
+// - the term is not compressed,
+// - integers use the smallest representation: SMALL_INTEGER_EXT, then INTEGER_EXT, then SMALL_BIG_EXT,
+// - atoms are encoded as UTF-8 atoms: SMALL_ATOM_UTF8_EXT, or ATOM_UTF8_EXT for atoms over 255 bytes,
+// - whatever the Go type of a value (int, uint8, Atom, String, List, ...), the same Erlang term has the same encoding,
+// - strings are encoded as binaries, never as STRING_EXT,
+// - lists of 1 to 65535 integers between 0 and 255 are encoded as STRING_EXT, like term_to_binary does,
+// - map keys are sorted in Erlang term order, whatever the Go map iteration order.
+//
+// The encoding returned by a Marshaler is written as is, so it has to be canonical itself.
+func CanonicalMarshal(term interface{}) ([]byte, error) {
+	data, err := Encode(term)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(TagETFVersion)
+	if err := compactLists(bytes.NewReader(data[1:]), &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// VerifyCanonical checks that data is the canonical encoding of a single term, as produced by CanonicalMarshal.
+// It is used to reject terms that were not encoded in canonical form, when a signature covers the canonical bytes.
+func VerifyCanonical(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := readVersion(r); err != nil {
+		return err
+	}
+	// STRING_EXT is decoded as the list it stands for, which the canonical form encodes back as STRING_EXT.
+	// Maps are decoded as entries, as their keys can be tuples or lists.
+	options := decodeOptions{stringsAsLists: true, mapsAsEntries: true}
+	term, err := decodeDynamic(&optionsReader{Reader: r, decodeOptions: options})
+	if err != nil {
+		return err
+	}
+
+	canonical, err := CanonicalMarshal(term)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(canonical, data) {
+		return ErrNotCanonical
+	}
+	return nil
+}
+
+// compactLists copies the next term from r to buf, encoding the lists of small integers it contains as STRING_EXT.
+func compactLists(r io.Reader, buf *bytes.Buffer) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return err
+	}
+
+	tag := int(byte1[0])
+	switch tag {
+	case TagSmallTuple, TagLargeTuple:
+		buf.WriteByte(byte1[0])
+		arity, err := readTupleArity(io.TeeReader(r, buf), tag)
+		if err != nil {
+			return err
+		}
+		return compactTerms(r, buf, arity)
+
+	case TagMap:
+		buf.WriteByte(byte1[0])
+		arity, err := readLength(io.TeeReader(r, buf), 4)
+		if err != nil {
+			return err
+		}
+		return compactTerms(r, buf, 2*arity)
+
+	case TagList:
+		length, err := readLength(r, 4)
+		if err != nil {
+			return err
+		}
+		// Elements, then the tail
+		var elems bytes.Buffer
+		small := length > 0 && length <= math.MaxUint16
+		for i := 0; i <= length; i++ {
+			start := elems.Len()
+			if err := compactLists(r, &elems); err != nil {
+				return err
+			}
+			elem := elems.Bytes()[start:]
+			if i < length {
+				small = small && len(elem) == 2 && elem[0] == TagSmallInteger
+			} else {
+				small = small && len(elem) == 1 && elem[0] == TagNil
+			}
+		}
+
+		if small {
+			buf.WriteByte(TagString)
+			if err := binary.Write(buf, binary.BigEndian, uint16(length)); err != nil {
+				return err
+			}
+			data := elems.Bytes()
+			for i := 0; i < length; i++ {
+				buf.WriteByte(data[2*i+1])
+			}
+			return nil
+		}
+		buf.WriteByte(TagList)
+		if err := binary.Write(buf, binary.BigEndian, uint32(length)); err != nil {
+			return err
+		}
+		_, err = buf.Write(elems.Bytes())
+		return err
+	}
+
+	// Other terms cannot contain lists: they are copied as is.
+	return skipTerm(io.TeeReader(io.MultiReader(bytes.NewReader(byte1), r), buf))
+}
+
+func compactTerms(r io.Reader, buf *bytes.Buffer, count int) error {
+	for i := 0; i < count; i++ {
+		if err := compactLists(r, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
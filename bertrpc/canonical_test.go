@@ -0,0 +1,159 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestCanonicalMarshal(t *testing.T) {
+	// The same Erlang term, built from different Go values.
+	term1 := bertrpc.T(bertrpc.A("signed"), bertrpc.L(int64(1), uint8(2), bertrpc.A("a"), []int{500}))
+	term2 := bertrpc.T(bertrpc.Atom{Value: "signed"}, []interface{}{uint16(1), int32(2), bertrpc.Atom{Value: "a"}, bertrpc.L(500)})
+
+	data1, err := bertrpc.CanonicalMarshal(term1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data2, err := bertrpc.CanonicalMarshal(term2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(data1, data2) {
+		t.Errorf("CanonicalMarshal: %v != %v", data1, data2)
+	}
+
+	// term_to_binary({signed, [1, 2, a, [500]]}) with UTF-8 atoms
+	expected := []byte{131, 104, 2, 119, 6, 115, 105, 103, 110, 101, 100, 108, 0, 0, 0, 4,
+		97, 1, 97, 2, 119, 1, 97, 108, 0, 0, 0, 1, 98, 0, 0, 1, 244, 106, 106}
+	if !bytes.Equal(data1, expected) {
+		t.Errorf("CanonicalMarshal: expected %v, actual %v", expected, data1)
+	}
+}
+
+// Like term_to_binary, lists of small integers are encoded as STRING_EXT.
+func TestCanonicalMarshalString(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected []byte
+	}{
+		// {ok, [1, 2, 3]}
+		{name: "string", input: bertrpc.T(bertrpc.A("ok"), []int{1, 2, 3}),
+			expected: []byte{131, 104, 2, 119, 2, 111, 107, 107, 0, 3, 1, 2, 3}},
+		// [[1], [256]]
+		{name: "nested", input: bertrpc.L([]int8{1}, bertrpc.L(256)),
+			expected: []byte{131, 108, 0, 0, 0, 2, 107, 0, 1, 1, 108, 0, 0, 0, 1, 98, 0, 0, 1, 0, 106, 106}},
+		// [1 | 2]
+		{name: "improper list", input: bertrpc.ImproperList{Elems: bertrpc.L(1), Tail: 2},
+			expected: []byte{131, 108, 0, 0, 0, 1, 97, 1, 97, 2}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			data, err := bertrpc.CanonicalMarshal(tc.input)
+			if err != nil {
+				st.Error(err)
+				return
+			}
+			if !bytes.Equal(data, tc.expected) {
+				st.Errorf("CanonicalMarshal: expected %v, actual %v", tc.expected, data)
+			}
+			if err := bertrpc.VerifyCanonical(data); err != nil {
+				st.Errorf("CanonicalMarshal result should be canonical: %s", err)
+			}
+		})
+	}
+}
+
+func TestCanonicalMarshalMaps(t *testing.T) {
+	// The same Erlang map, built from different Go values and insertion orders.
+	m1 := map[interface{}]interface{}{}
+	m1["b"] = int64(1)
+	m1[bertrpc.A("b")] = 2
+	m1[2] = uint8(3)
+	m1[bertrpc.A("a")] = int16(4)
+	m1[int64(1)] = []int{500}
+
+	m2 := map[interface{}]interface{}{}
+	m2[uint16(1)] = bertrpc.L(500)
+	m2[bertrpc.Atom{Value: "a"}] = 4
+	m2[int32(2)] = 3
+	m2[bertrpc.Atom{Value: "b"}] = uint32(2)
+	m2[bertrpc.S("b")] = 1
+
+	data1, err := bertrpc.CanonicalMarshal(bertrpc.T(bertrpc.A("signed"), m1))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data2, err := bertrpc.CanonicalMarshal(bertrpc.T(bertrpc.A("signed"), m2))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(data1, data2) {
+		t.Errorf("CanonicalMarshal: %v != %v", data1, data2)
+	}
+
+	// term_to_binary({signed, #{<<"b">> => 1, b => 2, 2 => 3, a => 4, 1 => [500]}}) with UTF-8 atoms
+	expected := []byte{131, 104, 2, 119, 6, 115, 105, 103, 110, 101, 100, 116, 0, 0, 0, 5,
+		97, 1, 108, 0, 0, 0, 1, 98, 0, 0, 1, 244, 106,
+		97, 2, 97, 3,
+		119, 1, 97, 97, 4,
+		119, 1, 98, 97, 2,
+		109, 0, 0, 0, 1, 98, 97, 1}
+	if !bytes.Equal(data1, expected) {
+		t.Errorf("CanonicalMarshal: expected %v, actual %v", expected, data1)
+	}
+}
+
+func TestCanonicalMarshalEqualMaps(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		m := map[string]int{}
+		for _, key := range []string{"one", "two", "three", "four", "five", "six"} {
+			m[key] = len(key)
+		}
+		data1, _ := bertrpc.CanonicalMarshal(m)
+		data2, _ := bertrpc.CanonicalMarshal(m)
+		if !bytes.Equal(data1, data2) {
+			t.Errorf("CanonicalMarshal is not stable: %v != %v", data1, data2)
+		}
+	}
+}
+
+func TestVerifyCanonical(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []byte
+		canonical bool
+	}{
+		{name: "{ok, 42}", input: []byte{131, 104, 2, 119, 2, 111, 107, 97, 42}, canonical: true},
+		{name: "string", input: []byte{131, 104, 2, 119, 2, 111, 107, 107, 0, 3, 1, 2, 3}, canonical: true},
+		{name: "list of large integers", input: []byte{131, 108, 0, 0, 0, 2, 97, 1, 98, 0, 0, 1, 44, 106}, canonical: true},
+		{name: "list of small integers", input: []byte{131, 104, 2, 119, 2, 111, 107, 108, 0, 0, 0, 3, 97, 1, 97, 2, 97, 3, 106}},
+		{name: "sorted map", input: []byte{131, 116, 0, 0, 0, 2, 97, 1, 97, 1, 119, 1, 97, 97, 2}, canonical: true},
+		{name: "map with a tuple key", input: []byte{131, 116, 0, 0, 0, 1, 104, 1, 97, 1, 97, 2}, canonical: true},
+		{name: "map with list keys", input: []byte{131, 116, 0, 0, 0, 2, 107, 0, 1, 1, 97, 3, 108, 0, 0, 0, 1, 98, 0, 0, 1, 0, 106, 97, 4},
+			canonical: true},
+		{name: "deprecated atom", input: []byte{131, 104, 2, 100, 0, 2, 111, 107, 97, 42}},
+		{name: "integer fitting in a small integer", input: []byte{131, 104, 2, 119, 2, 111, 107, 98, 0, 0, 0, 42}},
+		{name: "unsorted map", input: []byte{131, 116, 0, 0, 0, 2, 119, 1, 97, 97, 2, 97, 1, 97, 1}},
+		{name: "trailing data", input: []byte{131, 97, 42, 97, 42}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			err := bertrpc.VerifyCanonical(tc.input)
+			if tc.canonical && err != nil {
+				st.Errorf("term should be canonical: %s", err)
+			}
+			if !tc.canonical && err != bertrpc.ErrNotCanonical {
+				st.Errorf("term should not be canonical: %v", err)
+			}
+		})
+	}
+}
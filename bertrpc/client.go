@@ -1,10 +1,18 @@
 package bertrpc
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"time"
 )
 
 // Client create an HTTP client to that holds configuration parameters to make Bert-RPC calls.
+// A Client returned by Dial makes its calls over a TCP connection instead, with BERP framing.
 type Client struct {
 	// This is the endpoint used to access bert-rpc server
 	// For now, we only support HTTP endpoints.
@@ -13,6 +21,18 @@ type Client struct {
 	Token string
 
 	// TODO: make httpclient configurable
+
+	// conn is the TCP connection of a client returned by Dial.
+	conn *conn
+}
+
+// conn is a TCP connection to a BERT-RPC server. Calls are serialized, as each reply
+// is matched to its call by its order on the connection.
+type conn struct {
+	// busy holds a value while a call uses the connection. Unlike a mutex, waiting for it
+	// can be abandoned when the context of the call is done.
+	busy chan struct{}
+	net.Conn
 }
 
 // TODO: Support getting token for authentication.
@@ -21,6 +41,29 @@ func New(endpoint string) Client {
 	return client
 }
 
+// Dial connects to the BERT-RPC server at address, a host and port like "localhost:9999".
+// The client must be closed when it is not needed anymore.
+func Dial(address string) (*Client, error) {
+	return dial(context.Background(), address)
+}
+
+func dial(ctx context.Context, address string) (*Client, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: &conn{busy: make(chan struct{}, 1), Conn: c}}, nil
+}
+
+// Close closes the connection of a client returned by Dial.
+func (c Client) Close() error {
+	if c.conn == nil {
+		return errors.New("client is not connected")
+	}
+	return c.conn.Close()
+}
+
 // call is the internal structure to hold bert-rpc call parameters
 type call struct {
 	module   string
@@ -32,20 +75,173 @@ func (Client) NewCall(module string, function string, args ...interface{}) call
 	return call{module: module, function: function, args: args}
 }
 
+// Call calls Module:Function(Args...) on the server and returns its result, decoded like when
+// decoding into an interface{}. An {error, ...} reply from the server is returned as an RPCError.
+func (c Client) Call(module, function string, args ...interface{}) (interface{}, error) {
+	return c.CallContext(context.Background(), module, function, args...)
+}
+
+// CallContext is like Call, but gives up when ctx is done, returning ctx.Err().
+// Over TCP, the call in flight is aborted through the deadline of the connection, and the connection
+// is closed: the reply could still arrive later, and be taken as the reply to the next call.
+func (c Client) CallContext(ctx context.Context, module, function string, args ...interface{}) (interface{}, error) {
+	var result interface{}
+	err := c.exec(ctx, c.NewCall(module, function, args...), &result)
+	return result, err
+}
+
+// ErrCacheValid is returned by CallWithInfo when the server answers {info, valid, []}: the result
+// cached by the client for the validation token it sent is still valid, and no reply follows.
+var ErrCacheValid = errors.New("cached result is still valid")
+
+// CallWithInfo is like Call, with info packets sent before the call. Each info packet is an
+// {info, Command, Options} tuple. The BERT-RPC spec defines the commands:
+//   - cache, with the options [{validation, Token}]: the server answers {info, valid, []} if the result the
+//     client cached under that token is still valid, and CallWithInfo returns ErrCacheValid,
+//   - stream: the client does not support streamed requests and answers, so it must not be sent.
+//
+// Info packets the server sends before its reply are skipped.
+func (c Client) CallWithInfo(info []Tuple, module, function string, args ...interface{}) (interface{}, error) {
+	var buf bytes.Buffer
+	for _, t := range info {
+		if len(t.Elems) != 3 {
+			return nil, fmt.Errorf("%v is not an {info, Command, Options} tuple", t)
+		}
+		if tag, ok := atomText(t.Elems[0]); !ok || tag != "info" {
+			return nil, fmt.Errorf("%v is not an {info, Command, Options} tuple", t)
+		}
+		if err := encodePacket(&buf, t); err != nil {
+			return nil, err
+		}
+	}
+	if err := encodePacket(&buf, T(A("call"), A(module), A(function), args)); err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	err := c.roundTrip(context.Background(), buf, func(r io.Reader) error {
+		for {
+			frame, err := readFrame(r)
+			if err != nil {
+				return err
+			}
+			var answer Tuple
+			if Unmarshal(frame, &answer) != nil || len(answer.Elems) != 3 ||
+				answer.Elems[0] != (Atom{Value: "info"}) {
+				return decodeReplyPacket(bytes.NewReader(frame), &result)
+			}
+			if answer.Elems[1] == (Atom{Value: "valid"}) {
+				return ErrCacheValid
+			}
+		}
+	})
+	return result, err
+}
+
 func (c Client) Exec(call call, result interface{}) error {
+	return c.exec(context.Background(), call, result)
+}
+
+func (c Client) exec(ctx context.Context, call call, result interface{}) error {
 	// Prepare BERT-RPC Packet
 	buf, err := EncodeCall(call.module, call.function, call.args...)
 
 	if err != nil {
 		return err
 	}
+	return c.roundTrip(ctx, buf, func(r io.Reader) error {
+		return DecodeReply(r, result)
+	})
+}
+
+// Cast calls Module:Function(Args...) on the server without waiting for its result.
+// It only waits for the server to acknowledge the call with {noreply}.
+func (c Client) Cast(module, function string, args ...interface{}) error {
+	buf, err := EncodeCast(module, function, args...)
+	if err != nil {
+		return err
+	}
+	return c.roundTrip(context.Background(), buf, DecodeNoReply)
+}
+
+// roundTrip sends a packet to the server, and decodes its answer with decode, unless ctx is done first.
+func (c Client) roundTrip(ctx context.Context, packet bytes.Buffer, decode func(r io.Reader) error) error {
+	if c.conn != nil {
+		select {
+		case c.conn.busy <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-c.conn.busy }()
+		return c.conn.roundTrip(ctx, packet, decode)
+	}
 
 	// Use HTTP POST to trigger BERT-RPC call over HTTP
-	resp, err := http.Post(c.Endpoint, "application/bert", &buf)
+	req, err := http.NewRequest(http.MethodPost, c.Endpoint, &packet)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/bert")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return err
 	}
 	defer resp.Body.Close()
 
-	return DecodeReply(resp.Body, result)
+	if err := decode(resp.Body); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// roundTrip sends a packet on the connection and decodes the answer. When ctx is done first, the deadline
+// of the connection is moved to the past, so that a blocked read or write returns, and the connection is closed.
+func (c *conn) roundTrip(ctx context.Context, packet bytes.Buffer, decode func(r io.Reader) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stop := c.watch(ctx)
+
+	_, err := c.Write(packet.Bytes())
+	if err == nil {
+		err = decode(c)
+	}
+
+	if stop() {
+		if err != nil {
+			c.Close()
+			return ctx.Err()
+		}
+		// The answer was read before the deadline took effect: the connection can still be used.
+		c.SetDeadline(time.Time{})
+	}
+	return err
+}
+
+// watch moves the deadline of the connection to the past when ctx is done, until the returned function
+// is called. That function tells if the deadline was moved.
+func (c *conn) watch(ctx context.Context) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	stop, cancelled := make(chan struct{}), make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0))
+			cancelled <- true
+		case <-stop:
+			cancelled <- false
+		}
+	}()
+	return func() bool {
+		close(stop)
+		return <-cancelled
+	}
 }
@@ -0,0 +1,272 @@
+package bertrpc_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+// serveRPC answers each packet received on l with the packets returned by handle, until l is closed.
+func serveRPC(t *testing.T, l net.Listener, handle func(call bertrpc.Tuple) []interface{}) {
+	c, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	for {
+		var call bertrpc.Tuple
+		if err := bertrpc.ReadPacket(c, &call); err != nil {
+			return
+		}
+		for _, packet := range handle(call) {
+			if err := bertrpc.WritePacket(c, packet); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	go serveRPC(t, l, func(call bertrpc.Tuple) []interface{} {
+		switch call.Elems[2] {
+		case bertrpc.Atom{Value: "add"}:
+			args := call.Elems[3].([]interface{})
+			return bertrpc.L(bertrpc.T(bertrpc.A("reply"), args[0].(int64)+args[1].(int64)))
+		default:
+			return bertrpc.L(bertrpc.T(bertrpc.A("error"),
+				bertrpc.T(bertrpc.A("server"), 2, "UndefinedFunction", "function not found", bertrpc.L())))
+		}
+	})
+
+	client, err := bertrpc.Dial(l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Close()
+
+	// Several calls on the same connection
+	for i := 0; i < 3; i++ {
+		result, err := client.Call("calc", "add", i, 40)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if result != int64(i+40) {
+			t.Errorf("incorrect result: %#v (!= %d)", result, i+40)
+		}
+	}
+
+	_, err = client.Call("calc", "sub", 2, 1)
+	want := bertrpc.RPCError{Type: "server", Code: 2, Class: "UndefinedFunction", Detail: "function not found",
+		Backtrace: []string{}}
+	if rpcErr, ok := err.(bertrpc.RPCError); !ok || !reflect.DeepEqual(rpcErr, want) {
+		t.Errorf("incorrect error: %#v (!= %#v)", err, want)
+	}
+
+	// The connection is still usable after an error
+	if result, err := client.Call("calc", "add", 1, 1); err != nil || result != int64(2) {
+		t.Errorf("incorrect result: %#v (%v)", result, err)
+	}
+}
+
+func TestClientCallContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	go serveRPC(t, l, func(call bertrpc.Tuple) []interface{} {
+		if call.Elems[2] == (bertrpc.Atom{Value: "sleep"}) {
+			return nil
+		}
+		return bertrpc.L(bertrpc.T(bertrpc.A("reply"), bertrpc.A("ok")))
+	})
+
+	client, err := bertrpc.Dial(l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Close()
+
+	// A context done before the call does not send anything
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.CallContext(ctx, "timer", "ping"); err != context.Canceled {
+		t.Errorf("calling with a cancelled context: expected context.Canceled, actual %v", err)
+	}
+
+	// Cancelling a context once the call is over does not affect the connection
+	ctx, cancel = context.WithCancel(context.Background())
+	result, err := client.CallContext(ctx, "timer", "ping")
+	cancel()
+	if err != nil || result != (bertrpc.Atom{Value: "ok"}) {
+		t.Errorf("incorrect result: %#v, %v", result, err)
+	}
+	if _, err := client.Call("timer", "ping"); err != nil {
+		t.Errorf("calling after the context was cancelled: %s", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.CallContext(ctx, "timer", "sleep"); err != context.DeadlineExceeded {
+		t.Errorf("calling without answer: expected context.DeadlineExceeded, actual %v", err)
+	}
+	// The connection is closed, as the reply could still arrive
+	if _, err := client.Call("timer", "ping"); err == nil {
+		t.Errorf("calling on a connection closed by a timeout should fail")
+	}
+}
+
+// A call waiting for the connection returns when its context is done, even if the call using the
+// connection never gets an answer.
+func TestClientCallContextWaiting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	received := make(chan struct{})
+	go serveRPC(t, l, func(call bertrpc.Tuple) []interface{} {
+		close(received)
+		return nil
+	})
+
+	client, err := bertrpc.Dial(l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	hung := make(chan error)
+	go func() {
+		_, err := client.Call("timer", "sleep")
+		hung <- err
+	}()
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		_, err := client.CallContext(ctx, "timer", "ping")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("waiting for the connection: expected context.DeadlineExceeded, actual %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("a call waiting for the connection should return when its context is done")
+	}
+
+	client.Close()
+	if err := <-hung; err == nil {
+		t.Errorf("a call on a closed connection should fail")
+	}
+}
+
+func TestClientCast(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	casts := make(chan bertrpc.Tuple, 2)
+	go serveRPC(t, l, func(call bertrpc.Tuple) []interface{} {
+		casts <- call
+		switch call.Elems[2] {
+		case bertrpc.Atom{Value: "notify"}:
+			return bertrpc.L(bertrpc.T(bertrpc.A("noreply")))
+		case bertrpc.Atom{Value: "get"}:
+			// Not an acknowledgment of a cast
+			return bertrpc.L(bertrpc.T(bertrpc.A("reply"), 42))
+		default:
+			return bertrpc.L(bertrpc.T(bertrpc.A("error"),
+				bertrpc.T(bertrpc.A("user"), 1, "RuntimeError", "boom", bertrpc.L("log.erl:12"))))
+		}
+	})
+
+	client, err := bertrpc.Dial(l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Close()
+
+	if err := client.Cast("log", "notify", "started"); err != nil {
+		t.Error(err)
+		return
+	}
+	want := bertrpc.T(bertrpc.Atom{Value: "cast"}, bertrpc.Atom{Value: "log"}, bertrpc.Atom{Value: "notify"},
+		bertrpc.L("started"))
+	if call := <-casts; !reflect.DeepEqual(call, want) {
+		t.Errorf("incorrect cast: %#v (!= %#v)", call, want)
+	}
+
+	if err := client.Cast("log", "get"); err == nil {
+		t.Errorf("a reply to a cast should be an error")
+	}
+	<-casts
+	if _, ok := client.Cast("log", "crash").(bertrpc.RPCError); !ok {
+		t.Errorf("an error answer to a cast should be an RPCError")
+	}
+}
+
+func TestClientCallWithInfo(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	var token interface{}
+	go serveRPC(t, l, func(packet bertrpc.Tuple) []interface{} {
+		if packet.Elems[0] == (bertrpc.Atom{Value: "info"}) {
+			// [{validation, Token}]
+			token = packet.Elems[2].([]interface{})[0].(bertrpc.Tuple).Elems[1]
+			return nil
+		}
+		if token == "v1" {
+			return bertrpc.L(bertrpc.T(bertrpc.A("info"), bertrpc.A("valid"), bertrpc.L()))
+		}
+		// The server tells how the reply can be cached before sending it.
+		return bertrpc.L(
+			bertrpc.T(bertrpc.A("info"), bertrpc.A("cache"), bertrpc.L(bertrpc.T(bertrpc.A("access"), bertrpc.A("private")))),
+			bertrpc.T(bertrpc.A("reply"), "fresh"))
+	})
+
+	client, err := bertrpc.Dial(l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Close()
+
+	cache := func(token string) []bertrpc.Tuple {
+		return []bertrpc.Tuple{bertrpc.T(bertrpc.A("info"), bertrpc.A("cache"), bertrpc.L(bertrpc.T(bertrpc.A("validation"), token)))}
+	}
+	result, err := client.CallWithInfo(cache("v0"), "pages", "get", "index")
+	if err != nil || result != "fresh" {
+		t.Errorf("incorrect result: %#v (%v)", result, err)
+	}
+	if _, err := client.CallWithInfo(cache("v1"), "pages", "get", "index"); err != bertrpc.ErrCacheValid {
+		t.Errorf("a valid cache should return ErrCacheValid, not %v", err)
+	}
+
+	for _, info := range []bertrpc.Tuple{bertrpc.T(bertrpc.A("cache")), {}} {
+		if _, err := client.CallWithInfo([]bertrpc.Tuple{info}, "pages", "get"); err == nil {
+			t.Errorf("sending an info packet that is not {info, Command, Options} should fail: %v", info)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package bertrpc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ============================================================================
+// Compressed terms
+
+// term_to_binary(Term, [compressed]) produces a COMPRESSED wrapper after the version tag:
+// the uncompressed size of the term on 4 bytes, then the term compressed with zlib.
+
+// MarshalCompressed returns the encoding of a term compressed with zlib at the given level, like
+// term_to_binary(Term, [{compressed, Level}]). level is one of the compress/zlib levels, from
+// zlib.BestSpeed to zlib.BestCompression, or zlib.DefaultCompression.
+// As Erlang does, the term is returned uncompressed when compression does not make it smaller.
+func MarshalCompressed(term interface{}, level int) ([]byte, error) {
+	data, err := Marshal(term)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{TagETFVersion, TagCompressed})
+	if err := binary.Write(&buf, binary.BigEndian, uint32(len(data)-1)); err != nil {
+		return nil, err
+	}
+	zw, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	// The version tag is not compressed
+	if _, err := zw.Write(data[1:]); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// readHeader reads the version tag that starts every encoded term, and returns the reader the term itself
+// must be decoded from. For a compressed term, it is the uncompressed term, once its size was checked.
+// Otherwise, it is r, with the tag of the term read to detect compression put back in front of it.
+func readHeader(r io.Reader) (io.Reader, error) {
+	if err := readVersion(r); err != nil {
+		return nil, err
+	}
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return nil, err
+	}
+	if byte1[0] != TagCompressed {
+		return io.MultiReader(bytes.NewReader(byte1), r), nil
+	}
+
+	byte4 := make([]byte, 4)
+	if err := readFull(r, byte4); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(byte4))
+
+	// zlib may read past the end of the compressed data when r cannot be read byte by byte
+	if _, ok := r.(io.ByteReader); !ok {
+		r = byteReader{r}
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	// The term buffer grows with the data actually inflated, rather than with the announced size.
+	var term bytes.Buffer
+	if _, err := term.ReadFrom(io.LimitReader(zr, size+1)); err != nil {
+		return nil, err
+	}
+	if int64(term.Len()) != size {
+		return nil, fmt.Errorf("compressed term of %d bytes announced as %d bytes", term.Len(), size)
+	}
+	// Reach the end of the zlib stream, to check its checksum
+	if n, err := zr.Read(make([]byte, 1)); n > 0 || err != io.EOF {
+		return nil, fmt.Errorf("compressed term is larger than its announced %d bytes", size)
+	}
+	return bytes.NewReader(term.Bytes()), nil
+}
+
+// byteReader reads a reader one byte at a time.
+type byteReader struct {
+	io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	byte1 := make([]byte, 1)
+	if err := readFull(b.Reader, byte1); err != nil {
+		return 0, err
+	}
+	return byte1[0], nil
+}
@@ -0,0 +1,177 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+// compress wraps an encoded term like term_to_binary(Term, [compressed]).
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{131, 80, 0, 0, 0, 0})
+	binary.BigEndian.PutUint32(buf.Bytes()[2:], uint32(len(data)-1))
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data[1:])
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeCompressed(t *testing.T) {
+	term := bertrpc.L(bertrpc.A("ok"), bytes.Repeat([]byte("hello"), 100), 42)
+	data, err := bertrpc.Encode(term)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	input := compress(data)
+
+	var decoded []interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := bertrpc.L(bertrpc.Atom{Value: "ok"}, string(bytes.Repeat([]byte("hello"), 100)), int64(42))
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, want)
+	}
+
+	// The compressed term is read exactly, so that the next term can be decoded,
+	// even from a reader that cannot be read byte by byte.
+	stream := append(append([]byte{}, input...), 131, 97, 7)
+	dec := bertrpc.NewDecoder(iotest.OneByteReader(bytes.NewReader(stream)))
+	if err := dec.Decode(&decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	var i int
+	if err := dec.Decode(&i); err != nil || i != 7 {
+		t.Errorf("incorrect decoded value after a compressed term: %d (%v)", i, err)
+	}
+	if err := bertrpc.Unmarshal(stream[:len(input)], &decoded); err != nil {
+		t.Errorf("cannot unmarshal Erlang term: %s", err)
+	}
+	r := iotest.OneByteReader(bytes.NewReader(stream))
+	if err := bertrpc.Decode(r, &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if err := bertrpc.Decode(r, &i); err != nil || i != 7 {
+		t.Errorf("incorrect decoded value after a compressed term: %d (%v)", i, err)
+	}
+
+	// The uncompressed size must match
+	for _, size := range []byte{10, 255} {
+		invalid := append([]byte{}, input...)
+		invalid[5] = size
+		if err := bertrpc.Decode(bytes.NewBuffer(invalid), &decoded); err == nil {
+			t.Errorf("decoding a compressed term with an incorrect size should fail")
+		}
+	}
+}
+
+func TestDecodeReplyCompressed(t *testing.T) {
+	data, err := bertrpc.Encode(bertrpc.T(bertrpc.A("reply"), bytes.Repeat([]byte("hello"), 100)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	input := compress(data)
+
+	// The packet is followed by the {noreply} packet, that must still be readable
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(input)))
+	buf.Write(input)
+	buf.Write([]byte{0, 0, 0, 13, 131, 104, 1, 100, 0, 7, 110, 111, 114, 101, 112, 108, 121})
+
+	var result []byte
+	if err := bertrpc.DecodeReply(&buf, &result); err != nil {
+		t.Errorf("cannot decode compressed reply: %s", err)
+		return
+	}
+	if !bytes.Equal(result, bytes.Repeat([]byte("hello"), 100)) {
+		t.Errorf("incorrect decoded reply: %v", result)
+	}
+	if err := bertrpc.DecodeNoReply(&buf); err != nil {
+		t.Errorf("cannot decode the packet after a compressed reply: %s", err)
+	}
+}
+
+// Peek returns the tag of the uncompressed term, so that compressed terms can be told apart like others.
+func TestDecoderPeekCompressed(t *testing.T) {
+	data, err := bertrpc.Encode(bertrpc.L(bytes.Repeat([]byte("hello"), 100)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	input := append(compress(data), 131, 97, 7)
+
+	dec := bertrpc.NewDecoder(iotest.OneByteReader(bytes.NewReader(input)))
+	tag, err := dec.Peek()
+	if err != nil {
+		t.Errorf("cannot peek next term: %s", err)
+		return
+	}
+	if tag != bertrpc.TagList {
+		t.Errorf("incorrect tag: %d (!= %d)", tag, bertrpc.TagList)
+	}
+	var list [][]byte
+	if err := dec.Decode(&list); err != nil || len(list) != 1 || len(list[0]) != 500 {
+		t.Errorf("incorrect decoded value after Peek: %v (%v)", list, err)
+	}
+	var i int
+	if err := dec.Decode(&i); err != nil || i != 7 {
+		t.Errorf("incorrect decoded value after a compressed term: %d (%v)", i, err)
+	}
+
+	dec = bertrpc.NewDecoder(bytes.NewReader(compress(data)[:7]))
+	if _, err := dec.Peek(); err != io.ErrUnexpectedEOF {
+		t.Errorf("peeking a truncated compressed term should return io.ErrUnexpectedEOF: %v", err)
+	}
+}
+
+func TestMarshalCompressed(t *testing.T) {
+	term := bertrpc.T(bertrpc.A("data"), bytes.Repeat([]byte("hello"), 100))
+	data, err := bertrpc.MarshalCompressed(term, zlib.BestCompression)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	plain, _ := bertrpc.Marshal(term)
+	// The header gives the size of the term without its version tag
+	if !bytes.Equal(data[:6], []byte{131, 80, 0, 0, 2, 1}) || len(data) >= len(plain) {
+		t.Errorf("MarshalCompressed: incorrect compressed term %v", data)
+	}
+
+	var decoded struct {
+		Name    bertrpc.Atom
+		Payload []byte
+	}
+	if err := bertrpc.Unmarshal(data, &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if decoded.Name.Value != "data" || !bytes.Equal(decoded.Payload, bytes.Repeat([]byte("hello"), 100)) {
+		t.Errorf("incorrect decoded value: %#v", decoded)
+	}
+
+	// Small terms do not shrink: they are returned uncompressed.
+	data, err = bertrpc.MarshalCompressed(42, zlib.DefaultCompression)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(data, []byte{131, 97, 42}) {
+		t.Errorf("MarshalCompressed: expected %v, actual %v", []byte{131, 97, 42}, data)
+	}
+
+	if _, err := bertrpc.MarshalCompressed(42, 10); err == nil {
+		t.Errorf("MarshalCompressed with an invalid level should fail")
+	}
+}
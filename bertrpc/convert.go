@@ -0,0 +1,200 @@
+package bertrpc
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// ============================================================================
+// Conversion between structs and tuples, without encoding them
+
+// StructToTuple returns the tuple a struct is encoded as, with the same field rules as
+// Encode, but holding the Go values of the fields instead of their encoding.
+// v is a struct or a pointer to a struct. A tagged struct whose tag has no field gives a
+// tuple holding only the tag atom, where Encode writes the atom alone.
+func StructToTuple(v interface{}) (Tuple, error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return Tuple{}, fmt.Errorf("cannot convert %T to a tuple", v)
+	}
+	return structTuple(val)
+}
+
+func structTuple(v reflect.Value) (Tuple, error) {
+	fields := cachedStructFields(v.Type())
+	if fields.tagged {
+		tag := v.Field(0).String()
+		elems := []interface{}{A(tag)}
+		for _, i := range fields.byTag[tag] {
+			elems = append(elems, v.Field(i).Interface())
+		}
+		return Tuple{Elems: elems}, nil
+	}
+
+	if fields.err != nil {
+		return Tuple{}, fields.err
+	}
+	elems := make([]interface{}, len(fields.elems))
+	for j, i := range fields.elems {
+		elems[j] = v.Field(i).Interface()
+	}
+	return Tuple{Elems: elems}, nil
+}
+
+// TupleToStruct sets the fields of the struct pointed to by v from the elements of t,
+// with the same field rules as Decode. Elements are assigned to the fields holding them
+// when their type allows it: numbers are converted to the type of the field, atoms can be
+// assigned to Atom and string fields and tuples to struct fields, recursively.
+func TupleToStruct(t Tuple, v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot convert a tuple to %T: expecting a pointer to a struct", v)
+	}
+	return tupleStruct(t, val.Elem())
+}
+
+func tupleStruct(t Tuple, v reflect.Value) error {
+	fields := cachedStructFields(v.Type())
+	if fields.tagged {
+		if len(t.Elems) == 0 {
+			return fmt.Errorf("cannot convert an empty tuple to tagged struct %s", v.Type())
+		}
+		tag, ok := atomText(t.Elems[0])
+		if !ok {
+			return fmt.Errorf("cannot convert %T to the tag of struct %s", t.Elems[0], v.Type())
+		}
+		indexes := fields.byTag[tag]
+		if len(t.Elems)-1 != len(indexes) {
+			return fmt.Errorf("cannot convert tuple of length %d to struct %s tagged %s", len(t.Elems), v.Type(), tag)
+		}
+		v.Field(0).SetString(tag)
+		return setFields(v, indexes, t.Elems[1:])
+	}
+
+	if fields.err != nil {
+		return fields.err
+	}
+	if len(t.Elems) != len(fields.elems) {
+		return fmt.Errorf("cannot convert tuple of length %d to struct %s", len(t.Elems), v.Type())
+	}
+	return setFields(v, fields.elems, t.Elems)
+}
+
+func setFields(v reflect.Value, indexes []int, elems []interface{}) error {
+	for j, i := range indexes {
+		if err := setValue(v.Field(i), elems[j]); err != nil {
+			return fmt.Errorf("field %s of %s: %s", v.Type().Field(i).Name, v.Type(), err)
+		}
+	}
+	return nil
+}
+
+// setValue sets val to term, converting it the way the decoder would.
+func setValue(val reflect.Value, term interface{}) error {
+	if term == nil {
+		val.Set(reflect.Zero(val.Type()))
+		return nil
+	}
+
+	t := reflect.ValueOf(term)
+	switch {
+	case t.Type().AssignableTo(val.Type()):
+		val.Set(t)
+		return nil
+	case isNumberKind(t.Kind()) && isNumberKind(val.Kind()):
+		if !fitsNumber(t, val.Type()) {
+			return ErrRange
+		}
+		val.Set(t.Convert(val.Type()))
+		return nil
+	}
+
+	if atom, ok := atomText(term); ok {
+		switch {
+		case val.Type() == reflect.TypeOf(Atom{}):
+			val.Set(reflect.ValueOf(Atom{Value: atom}))
+			return nil
+		case val.Kind() == reflect.String:
+			val.SetString(atom)
+			return nil
+		}
+	}
+	if x, ok := term.(Tuple); ok {
+		switch {
+		case val.Kind() == reflect.Struct:
+			return tupleStruct(x, val)
+		case val.Kind() == reflect.Ptr && val.Type().Elem().Kind() == reflect.Struct:
+			elem := reflect.New(val.Type().Elem())
+			if err := tupleStruct(x, elem.Elem()); err != nil {
+				return err
+			}
+			val.Set(elem)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot assign %T to %s", term, val.Type())
+}
+
+// atomText returns the text of an Atom, or of a String holding an atom like the ones returned by A.
+func atomText(term interface{}) (string, bool) {
+	switch t := term.(type) {
+	case Atom:
+		return t.Value, true
+	case String:
+		return t.Value, t.IsAtom()
+	}
+	return "", false
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUintKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+// fitsNumber reports whether the number n keeps its value once converted to typ, so that 300 in
+// a uint8, -1 in a uint or 1.5 in an int are rejected.
+func fitsNumber(n reflect.Value, typ reflect.Type) bool {
+	target := reflect.Zero(typ)
+	switch {
+	case isIntKind(n.Kind()):
+		i := n.Int()
+		switch {
+		case isIntKind(typ.Kind()):
+			return !target.OverflowInt(i)
+		case isUintKind(typ.Kind()):
+			return i >= 0 && !target.OverflowUint(uint64(i))
+		}
+		f := n.Convert(typ).Float()
+		return f < math.MaxInt64 && int64(f) == i
+	case isUintKind(n.Kind()):
+		u := n.Uint()
+		switch {
+		case isIntKind(typ.Kind()):
+			return u <= math.MaxInt64 && !target.OverflowInt(int64(u))
+		case isUintKind(typ.Kind()):
+			return !target.OverflowUint(u)
+		}
+		f := n.Convert(typ).Float()
+		return f < math.MaxUint64 && uint64(f) == u
+	}
+
+	f := n.Float()
+	switch {
+	case isIntKind(typ.Kind()):
+		return f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 && !target.OverflowInt(int64(f))
+	case isUintKind(typ.Kind()):
+		return f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 && !target.OverflowUint(uint64(f))
+	}
+	return !target.OverflowFloat(f)
+}
@@ -0,0 +1,119 @@
+package bertrpc_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+type segment struct {
+	Name  string
+	From  coordinate
+	To    *coordinate
+	Count uint8 `bert:"1"`
+}
+
+type coordinate struct {
+	X, Y int
+}
+
+func TestStructToTuple(t *testing.T) {
+	s := segment{Name: "a", From: coordinate{X: 1, Y: 2}, To: &coordinate{X: 3, Y: 4}, Count: 7}
+	tuple, err := bertrpc.StructToTuple(&s)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := bertrpc.T(uint8(7), "a", coordinate{X: 1, Y: 2}, &coordinate{X: 3, Y: 4})
+	if !reflect.DeepEqual(tuple, want) {
+		t.Errorf("incorrect tuple: %#v (!= %#v)", tuple, want)
+	}
+
+	var decoded segment
+	if err := bertrpc.TupleToStruct(tuple, &decoded); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(decoded, s) {
+		t.Errorf("incorrect struct: %#v (!= %#v)", decoded, s)
+	}
+
+	tagged, err := bertrpc.StructToTuple(lookupResult{Tag: "ok", Value: 42})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want := bertrpc.T(bertrpc.A("ok"), 42); !reflect.DeepEqual(tagged, want) {
+		t.Errorf("incorrect tuple: %#v (!= %#v)", tagged, want)
+	}
+
+	if _, err := bertrpc.StructToTuple(42); err == nil {
+		t.Errorf("converting an int to a tuple should fail")
+	}
+}
+
+// Elements are converted like when they are decoded.
+func TestTupleToStruct(t *testing.T) {
+	tuple := bertrpc.T(int64(7), "a", bertrpc.T(int64(1), int64(2)), bertrpc.T(int64(3), int64(4)))
+	var s segment
+	if err := bertrpc.TupleToStruct(tuple, &s); err != nil {
+		t.Error(err)
+		return
+	}
+	want := segment{Name: "a", From: coordinate{X: 1, Y: 2}, To: &coordinate{X: 3, Y: 4}, Count: 7}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("incorrect struct: %#v (!= %#v)", s, want)
+	}
+
+	var result lookupResult
+	if err := bertrpc.TupleToStruct(bertrpc.T(bertrpc.A("error"), bertrpc.A("not_found")), &result); err != nil {
+		t.Error(err)
+		return
+	}
+	if want := (lookupResult{Tag: "error", Reason: bertrpc.Atom{Value: "not_found"}}); result != want {
+		t.Errorf("incorrect struct: %#v (!= %#v)", result, want)
+	}
+
+	errors := []bertrpc.Tuple{
+		bertrpc.T(int64(300), "a", bertrpc.T(int64(1), int64(2)), nil),
+		bertrpc.T(int64(7), "a", bertrpc.T(int64(1)), nil),
+		bertrpc.T(int64(7), int64(1), bertrpc.T(int64(1), int64(2)), nil),
+		bertrpc.T(int64(7), "a"),
+	}
+	for _, tuple := range errors {
+		if err := bertrpc.TupleToStruct(tuple, &s); err == nil {
+			t.Errorf("converting %v should fail", tuple)
+		}
+	}
+}
+
+func TestTupleToStructNumbers(t *testing.T) {
+	var s struct {
+		Unsigned uint64
+		Small    uint32
+		Signed   int64
+		Float    float32
+	}
+	if err := bertrpc.TupleToStruct(bertrpc.T(int64(1), uint8(2), uint64(3), int64(4)), &s); err != nil {
+		t.Error(err)
+		return
+	}
+	if s.Unsigned != 1 || s.Small != 2 || s.Signed != 3 || s.Float != 4 {
+		t.Errorf("incorrect struct: %#v", s)
+	}
+
+	errors := []bertrpc.Tuple{
+		bertrpc.T(int64(-1), uint32(0), int64(0), float32(0)),
+		bertrpc.T(uint64(0), int32(-1), int64(0), float32(0)),
+		bertrpc.T(uint64(0), uint32(0), uint64(1<<63+5), float32(0)),
+		bertrpc.T(uint64(0), float64(-1), int64(0), float32(0)),
+		bertrpc.T(uint64(0), uint32(0), float64(1.5), float32(0)),
+		bertrpc.T(uint64(0), uint32(0), int64(0), float64(1e40)),
+	}
+	for _, tuple := range errors {
+		if err := bertrpc.TupleToStruct(tuple, &s); err == nil {
+			t.Errorf("converting %v should fail", tuple)
+		}
+	}
+}
@@ -1,32 +1,111 @@
 package bertrpc
 
 import (
+	"bytes"
+	"encoding"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"reflect"
+	"strconv"
+	"time"
 )
 
 var ErrRange = errors.New("value out of range")
 
+// Unmarshaler is the interface implemented by types that can decode an Erlang term into themselves.
+// UnmarshalBERT receives the External Term Format encoding of the term, including the version tag.
+// It takes precedence over TermUnmarshaler, encoding.BinaryUnmarshaler and the decoding based on the
+// type of the target.
+type Unmarshaler interface {
+	UnmarshalBERT(data []byte) error
+}
+
+// TermUnmarshaler is the interface implemented by types that can rebuild themselves from a decoded term.
+// FromTerm receives the term as decoded into an interface{}: Atom, Tuple, []interface{}, int64 and so on.
+// It is easier to implement than Unmarshaler, which has to parse the encoded bytes. A type implementing
+// both is decoded with Unmarshaler; TermUnmarshaler takes precedence over encoding.BinaryUnmarshaler.
+type TermUnmarshaler interface {
+	FromTerm(term interface{}) error
+}
+
+// Decode reads a term, starting with the version tag, into the value term points to.
+// Each value is decoded using, in order of precedence:
+//   - the Unmarshaler interface,
+//   - the TermUnmarshaler interface, which receives the term decoded into an interface{},
+//   - time.Time, decoded from an os:timestamp() tuple, although it implements encoding.BinaryUnmarshaler,
+//   - the encoding.BinaryUnmarshaler interface, which receives the content of an Erlang binary,
+//   - the decoding based on the type of the target, relying on reflection, including for interfaces
+//     registered with RegisterUnion.
 func Decode(r io.Reader, term interface{}) error {
+	r, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	return decodeData(r, term)
+}
+
+// DecodeNoHeader decodes a term that is not preceded by the version tag, like fragments of the
+// distribution protocol, into term. Decode remains strict and requires the version tag.
+// As compression is signaled after the version tag, compressed terms cannot be decoded this way.
+func DecodeNoHeader(r io.Reader, term interface{}) error {
+	return decodeData(r, term)
+}
+
+// Unmarshal decodes the Erlang term encoded in data, version tag included, into term.
+// It is the counterpart of Marshal. Bytes left after the term are an error, as they
+// usually reveal a framing problem.
+func Unmarshal(data []byte, term interface{}) error {
+	r := bytes.NewReader(data)
+	if err := Decode(r, term); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%d trailing bytes after Erlang term", r.Len())
+	}
+	return nil
+}
+
+// Read Erlang Term Format "magic byte"
+func readVersion(r io.Reader) error {
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
+	_, err := io.ReadFull(r, byte1)
 	if err != nil {
 		return err
 	}
 
-	// Read Erlang Term Format "magic byte"
 	if byte1[0] != byte(TagETFVersion) {
 		// Bad Version tag (aka 'magic number')
 		return fmt.Errorf("incorrect Erlang Term version tag: %d", byte1[0])
 	}
-
-	return decodeData(r, term)
+	return nil
 }
 
 func decodeData(r io.Reader, term interface{}) error {
+	// Types that know how to decode themselves come first, in the order documented on Decode.
+	switch t := term.(type) {
+	case Unmarshaler:
+		return decodeUnmarshaler(r, t)
+	case TermUnmarshaler:
+		value, err := decodeDynamic(r)
+		if err != nil {
+			return err
+		}
+		return t.FromTerm(value)
+	case *time.Time:
+		// Symmetric with the encoding of time.Time, although it implements encoding.BinaryUnmarshaler
+		ts, err := decodeTimestamp(r)
+		if err == nil {
+			*t = ts
+		}
+		return err
+	case encoding.BinaryUnmarshaler:
+		return decodeBinaryUnmarshaler(r, t)
+	}
+
 	// Resolve pointers
 	val := reflect.ValueOf(term)
 	if val.Kind() == reflect.Ptr {
@@ -43,17 +122,111 @@ func decodeData(r io.Reader, term interface{}) error {
 			val.SetInt(i)
 		}
 		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// Decoded as a big.Int, so that values above math.MaxInt64 fit in a uint64.
+		i, err := decodeBigInt(r)
+		if err != nil {
+			return err
+		}
+		if i.Sign() < 0 || i.BitLen() > val.Type().Bits() {
+			return ErrRange
+		}
+		val.SetUint(i.Uint64())
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := decodeFloat(r)
+		if err != nil {
+			return err
+		}
+		if val.OverflowFloat(f) {
+			return ErrRange
+		}
+		val.SetFloat(f)
+		return nil
 	case reflect.String:
 		s, err := decodeString(r)
 		if err == nil {
 			val.SetString(s)
 		}
 		return err
+	case reflect.Bool:
+		b, err := decodeBool(r)
+		if err == nil {
+			val.SetBool(b)
+		}
+		return err
+	case reflect.Ptr:
+		if val.Type().Elem().Kind() == reflect.Bool {
+			return decodeOptionalBool(r, val)
+		}
+		if val.Type().Elem() == reflect.TypeOf(big.Int{}) {
+			n, err := decodeBigInt(r)
+			if err == nil {
+				val.Set(reflect.ValueOf(n))
+			}
+			return err
+		}
+		return decodePointer(r, val)
+	case reflect.Interface:
+		if val.NumMethod() > 0 {
+			if byTag, ok := unionTypes(val.Type()); ok {
+				return decodeUnion(r, val, byTag)
+			}
+			return fmt.Errorf("unhandled decoding target: %s", val.Type())
+		}
+		// Without a concrete target type, the term is decoded based on its Erlang type.
+		v, err := decodeDynamic(r)
+		if err == nil {
+			val.Set(reflect.ValueOf(v))
+		}
+		return err
+	case reflect.Map:
+		return decodeMap(r, val)
+	case reflect.Slice:
+		switch val.Type().Elem() {
+		case reflect.TypeOf(MapEntry{}):
+			return decodeMapEntries(r, val)
+		case reflect.TypeOf(byte(0)):
+			return decodeBytes(r, val)
+		}
+		return decodeList(r, val)
 	case reflect.Struct:
 		// Wrapper for basic types
-		if val.Type().Name() == "String" {
+		if val.Type() == reflect.TypeOf(String{}) {
 			return decodeBertString(r, val)
 		}
+		if val.Type() == reflect.TypeOf(big.Int{}) {
+			n, err := decodeBigInt(r)
+			if err == nil {
+				val.Set(reflect.ValueOf(n).Elem())
+			}
+			return err
+		}
+		if val.Type() == reflect.TypeOf(big.Rat{}) {
+			return decodeBigRat(r, val)
+		}
+		if val.Type() == reflect.TypeOf(Pid{}) {
+			return decodePid(r, val)
+		}
+		if val.Type() == reflect.TypeOf(Reference{}) {
+			return decodeReference(r, val)
+		}
+		if val.Type() == reflect.TypeOf(Port{}) {
+			return decodePort(r, val)
+		}
+		if val.Type() == reflect.TypeOf(ImproperList{}) {
+			return decodeImproperList(r, val)
+		}
+		if val.Type() == reflect.TypeOf(Tuple{}) {
+			return decodeTuple(r, val)
+		}
+		if val.Type() == reflect.TypeOf(Atom{}) {
+			atom, err := readAtom(r)
+			if err == nil {
+				val.Field(0).SetString(atom)
+			}
+			return err
+		}
 		return decodeStruct(r, val)
 
 	default:
@@ -61,6 +234,38 @@ func decodeData(r io.Reader, term interface{}) error {
 	}
 }
 
+// ============================================================================
+// Decode types implementing their own decoding
+
+func decodeUnmarshaler(r io.Reader, u Unmarshaler) error {
+	// Capture the raw bytes of the term while skipping it.
+	var raw bytes.Buffer
+	raw.WriteByte(TagETFVersion)
+	if err := skipTerm(io.TeeReader(r, &raw)); err != nil {
+		return err
+	}
+	return u.UnmarshalBERT(raw.Bytes())
+}
+
+// Only Erlang binaries can be decoded into an encoding.BinaryUnmarshaler.
+func decodeBinaryUnmarshaler(r io.Reader, u encoding.BinaryUnmarshaler) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return err
+	}
+	if int(byte1[0]) != TagBinary {
+		return TagError{Expected: TagBinary, Got: int(byte1[0])}
+	}
+
+	data, err := decodeString4(r)
+	if err != nil {
+		return err
+	}
+	return u.UnmarshalBinary(data)
+}
+
 // ============================================================================
 // Decode basic types
 
@@ -68,75 +273,118 @@ func decodeData(r io.Reader, term interface{}) error {
 func decodeInt(r io.Reader) (int64, error) {
 	// Read Tag
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
+	err := readFull(r, byte1)
 	if err != nil {
 		return 0, err
 	}
+	return decodeIntBody(r, int(byte1[0]))
+}
+
+// decodeIntBody decodes an integer whose tag has already been read.
+func decodeIntBody(r io.Reader, tag int) (int64, error) {
+	byte1 := make([]byte, 1)
 
 	// Compare expected type
-	switch int(byte1[0]) {
+	switch tag {
 
 	case TagSmallInteger:
-		_, err := r.Read(byte1)
-		if err != nil && err != io.EOF {
+		if err := readFull(r, byte1); err != nil {
 			return 0, err
 		}
 		return int64(byte1[0]), nil
 
 	case TagInteger:
 		byte4 := make([]byte, 4)
-		n, err := r.Read(byte4)
-		if err != nil && err != io.EOF {
+		if err := readFull(r, byte4); err != nil {
 			return 0, err
 		}
-		if n < 4 {
-			return 0, fmt.Errorf("cannot decode integer, only %d bytes read", n)
-		}
 		var32 := int32(binary.BigEndian.Uint32(byte4))
+		if optionsOf(r).canonicalIntegers && var32 >= 0 && var32 <= 255 {
+			return 0, ErrNotCanonical
+		}
 		return int64(var32), nil
 	case TagBigInteger:
 		byteN := make([]byte, 1)
 		byteSign := make([]byte, 1)
-		_, err := r.Read(byteN)
-		if err != nil {
+		if err := readFull(r, byteN); err != nil {
 			return 0, err
 		}
-		_, err = r.Read(byteSign)
-		if err != nil {
+		if err := readFull(r, byteSign); err != nil {
 			return 0, err
 		}
 		N := int(byteN[0])
 		Sign := int(byteSign[0])
 		byteD := make([]byte, N)
-		n, err := r.Read(byteD)
-		if err != nil && err != io.EOF {
+		if err := readFull(r, byteD); err != nil {
 			return 0, err
 		}
-		if n != N {
-			return 0, errors.New("parse big integer error")
+		// Digits are little-endian: the magnitude is built from the most significant one,
+		// checking before each shift that it cannot overflow.
+		var magnitude uint64
+		for idx := N - 1; idx >= 0; idx-- {
+			if magnitude > math.MaxUint64>>8 {
+				return 0, ErrRange
+			}
+			magnitude = magnitude<<8 | uint64(byteD[idx])
 		}
-		var value int64
-		var B int64
-		B = 1
-		for idx := 0; idx < N; idx++ {
-			d64 := int64(byteD[idx])
-			value += int64(d64 * B)
-			B *= 256
+		// The magnitude of math.MinInt64 is one more than math.MaxInt64:
+		// it wraps around to itself when converted, and stays the same once negated.
+		if Sign == 1 && magnitude > 1<<63 || Sign != 1 && magnitude > math.MaxInt64 {
+			return 0, ErrRange
 		}
+		value := int64(magnitude)
 		if Sign == 1 {
 			value = -value
 		}
+		// A big integer must not fit in an INTEGER_EXT, nor have unneeded leading zero digits
+		if optionsOf(r).canonicalIntegers &&
+			(value >= math.MinInt32 && value <= math.MaxInt32 || N == 0 || byteD[N-1] == 0) {
+			return 0, ErrNotCanonical
+		}
 		return value, nil
 	}
 
-	return 0, fmt.Errorf("incorrect type")
+	return 0, TagError{Expected: TagSmallInteger, Got: tag}
+}
+
+func decodeFloat(r io.Reader) (float64, error) {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return 0, err
+	}
+	return decodeFloatBody(r, int(byte1[0]))
+}
+
+// decodeFloatBody decodes a float whose tag has already been read.
+func decodeFloatBody(r io.Reader, tag int) (float64, error) {
+	switch tag {
+	case TagNewFloat:
+		// IEEE 754 float, on 8 bytes
+		byte8 := make([]byte, 8)
+		if err := readFull(r, byte8); err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(byte8)), nil
+
+	case TagFloat:
+		// Legacy float, formatted as a string with "%.20e" on 31 bytes, padded with zeros
+		byte31 := make([]byte, 31)
+		if err := readFull(r, byte31); err != nil {
+			return 0, err
+		}
+		return strconv.ParseFloat(string(bytes.TrimRight(byte31, "\x00")), 64)
+	}
+
+	return 0, TagError{Expected: TagNewFloat, Got: tag}
 }
 
 // We can decode several Erlang types in a string: Atom (Deprecated), AtomUTF8, Binary, CharList.
 func decodeString(r io.Reader) (string, error) {
 	// Read Tag
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
+	err := readFull(r, byte1)
 	if err != nil {
 		return "", err
 	}
@@ -145,13 +393,12 @@ func decodeString(r io.Reader) (string, error) {
 	dataType := int(byte1[0])
 	switch dataType {
 
-	case TagSmallAtomUTF8:
-		data, err := decodeString1(r)
-		return string(data), err
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		return readAtomBody(r, dataType)
 
-	case TagDeprecatedAtom, TagAtomUTF8, TagString:
+	case TagString:
 		data, err := decodeString2(r)
-		return string(data), err
+		return latin1(data), err
 
 	case TagBinary:
 		data, err := decodeString4(r)
@@ -160,29 +407,28 @@ func decodeString(r io.Reader) (string, error) {
 	case TagList:
 		data, err := decodeCharList(r)
 		return string(data), err
+
+	case TagNil:
+		// The empty charlist
+		return "", nil
 	}
 
-	return "", fmt.Errorf("incorrect type: %d", dataType)
+	return "", TagError{Expected: TagBinary, Got: dataType}
 }
 
 func decodeString1(r io.Reader) ([]byte, error) {
 	// Length:
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
-	if err != nil {
+	if err := readFull(r, byte1); err != nil {
 		return []byte{}, err
 	}
 	length := int(byte1[0])
 
 	// Content:
 	data := make([]byte, length)
-	n, err := r.Read(data)
-	if err != nil && err != io.EOF {
+	if err := readFull(r, data); err != nil {
 		return []byte{}, err
 	}
-	if n < length {
-		return []byte{}, fmt.Errorf("truncated data")
-	}
 	return data, nil
 
 }
@@ -191,21 +437,16 @@ func decodeString1(r io.Reader) ([]byte, error) {
 func decodeString2(r io.Reader) ([]byte, error) {
 	// Length:
 	l := make([]byte, 2)
-	_, err := r.Read(l)
-	if err != nil {
+	if err := readFull(r, l); err != nil {
 		return []byte{}, err
 	}
 	length := int(binary.BigEndian.Uint16(l))
 
 	// Content:
 	data := make([]byte, length)
-	n, err := r.Read(data)
-	if err != nil && err != io.EOF {
+	if err := readFull(r, data); err != nil {
 		return []byte{}, err
 	}
-	if n < length {
-		return []byte{}, fmt.Errorf("truncated data")
-	}
 
 	return data, nil
 }
@@ -214,36 +455,62 @@ func decodeString2(r io.Reader) ([]byte, error) {
 func decodeString4(r io.Reader) ([]byte, error) {
 	// Length:
 	l := make([]byte, 4)
-	_, err := r.Read(l)
-	if err != nil {
+	if err := readFull(r, l); err != nil {
 		return []byte{}, err
 	}
 	length := int(binary.BigEndian.Uint32(l))
 
 	// Content:
-	data := make([]byte, length)
-	n, err := r.Read(data)
-	if err != nil && err != io.EOF {
+	data, err := readBytes(r, length)
+	if err != nil {
 		return []byte{}, err
 	}
-	if n < length {
-		return []byte{}, fmt.Errorf("truncated data")
-	}
 
 	return data, nil
 }
 
+// Binaries are decoded into []byte as is, without going through a string.
+// So are the bytes of a STRING_EXT, rather than their Latin-1 characters.
+func decodeBytes(r io.Reader, val reflect.Value) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return err
+	}
+
+	var data []byte
+	var err error
+	switch int(byte1[0]) {
+	case TagBinary:
+		data, err = decodeString4(r)
+	case TagString:
+		data, err = decodeString2(r)
+	default:
+		return TagError{Expected: TagBinary, Got: int(byte1[0])}
+	}
+	if err != nil {
+		return err
+	}
+	val.SetBytes(data)
+	return nil
+}
+
+// STRING_EXT holds one byte per character: they are Latin-1 code points, not UTF-8.
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
 // Decode a string with length on 32 bits.
 func decodeCharList(r io.Reader) ([]rune, error) {
 	// Count:
 	byte4 := make([]byte, 4)
-	n, err := r.Read(byte4)
-	if err != nil {
+	if err := readFull(r, byte4); err != nil {
 		return []rune{}, err
 	}
-	if n < 4 {
-		return []rune{}, fmt.Errorf("truncated List data")
-	}
 	count := int(binary.BigEndian.Uint32(byte4))
 
 	s := []rune("")
@@ -267,35 +534,216 @@ func decodeCharList(r io.Reader) ([]rune, error) {
 	return s, nil
 }
 
+// Erlang booleans are the atoms true and false.
+func decodeBool(r io.Reader) (bool, error) {
+	atom, err := readAtom(r)
+	if err != nil {
+		return false, err
+	}
+	return atomToBool(atom)
+}
+
+// decodeOptionalBool decodes true, false, nil or undefined into a *bool, nil and undefined being
+// decoded as a nil pointer like for the other pointers.
+func decodeOptionalBool(r io.Reader, val reflect.Value) error {
+	atom, err := readAtom(r)
+	if err != nil {
+		return err
+	}
+	if atom == "nil" || atom == "undefined" {
+		val.Set(reflect.Zero(val.Type()))
+		return nil
+	}
+
+	b, err := atomToBool(atom)
+	if err != nil {
+		return err
+	}
+	ptr := reflect.New(val.Type().Elem())
+	ptr.Elem().SetBool(b)
+	val.Set(ptr)
+	return nil
+}
+
+// decodePointer decodes a term into the value a pointer points to, allocating it when the pointer is nil.
+// The atoms nil and undefined are decoded as a nil pointer.
+func decodePointer(r io.Reader, val reflect.Value) error {
+	// The bytes read to look for nil are replayed to decode anything else.
+	var raw bytes.Buffer
+	tee := &optionsReader{Reader: io.TeeReader(r, &raw), decodeOptions: optionsOf(r)}
+	byte1 := make([]byte, 1)
+	if err := readFull(tee, byte1); err != nil {
+		return err
+	}
+	switch tag := int(byte1[0]); tag {
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		atom, err := readAtomBody(tee, tag)
+		if err != nil {
+			return err
+		}
+		if atom == "nil" || atom == "undefined" {
+			val.Set(reflect.Zero(val.Type()))
+			return nil
+		}
+	}
+
+	ptr := val
+	if val.IsNil() {
+		ptr = reflect.New(val.Type().Elem())
+	}
+	replay := &optionsReader{Reader: io.MultiReader(&raw, r), decodeOptions: optionsOf(r)}
+	if err := decodeData(replay, ptr.Interface()); err != nil {
+		return err
+	}
+	val.Set(ptr)
+	return nil
+}
+
+func atomToBool(atom string) (bool, error) {
+	switch atom {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("cannot decode atom %s to bool", atom)
+	}
+}
+
+// decodeList decodes an Erlang list into a Go slice, decoding each element into the element type of the slice.
+// Charlists sent as STRING_EXT can be decoded into slices of integers, like []int or []rune.
+func decodeList(r io.Reader, val reflect.Value) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return err
+	}
+
+	elemType := val.Type().Elem()
+	switch int(byte1[0]) {
+	case TagNil:
+		val.Set(reflect.MakeSlice(val.Type(), 0, 0))
+		return nil
+
+	case TagString:
+		switch elemType.Kind() {
+		case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
+		case reflect.Interface:
+			if elemType.NumMethod() != 0 {
+				return TagError{Expected: TagList, Got: TagString}
+			}
+		default:
+			return TagError{Expected: TagList, Got: TagString}
+		}
+		data, err := decodeString2(r)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(val.Type(), len(data), len(data))
+		for i, b := range data {
+			if elemType.Kind() == reflect.Interface {
+				// Like the elements of a LIST_EXT decoded into an interface{}
+				slice.Index(i).Set(reflect.ValueOf(int64(b)))
+			} else {
+				slice.Index(i).SetInt(int64(b))
+			}
+		}
+		val.Set(slice)
+		return nil
+
+	case TagList:
+		byte4 := make([]byte, 4)
+		if err := readFull(r, byte4); err != nil {
+			return err
+		}
+		count := int(binary.BigEndian.Uint32(byte4))
+
+		slice := reflect.MakeSlice(val.Type(), 0, preallocSize(count))
+		for i := 0; i < count; i++ {
+			elem := reflect.New(elemType)
+			if err := decodeData(r, elem.Interface()); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, elem.Elem())
+		}
+		// Check that we have the list termination mark
+		if err := decodeNil(r); err != nil {
+			return err
+		}
+		val.Set(slice)
+		return nil
+
+	default:
+		return TagError{Expected: TagList, Got: int(byte1[0])}
+	}
+}
+
+// decodeTuple decodes any tuple into a Tuple, decoding its elements like when decoding into an interface{}.
+// It suits tuples whose shape varies, where a struct would not fit.
+func decodeTuple(r io.Reader, val reflect.Value) error {
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return err
+	}
+	tag := int(byte1[0])
+	if tag != TagSmallTuple && tag != TagLargeTuple {
+		return TagError{Expected: TagSmallTuple, Got: tag}
+	}
+
+	tuple, err := decodeDynamicBody(r, tag)
+	if err == nil {
+		val.Set(reflect.ValueOf(tuple))
+	}
+	return err
+}
+
+// decodeImproperList decodes any list into an ImproperList, decoding its elements and its tail without
+// a target type. The tail of a proper list is decoded as nil.
+func decodeImproperList(r io.Reader, val reflect.Value) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return err
+	}
+
+	var list ImproperList
+	switch int(byte1[0]) {
+	case TagNil:
+	case TagList:
+		if list.Elems, list.Tail, err = decodeDynamicListElems(r); err != nil {
+			return err
+		}
+	default:
+		return TagError{Expected: TagList, Got: int(byte1[0])}
+	}
+
+	val.Set(reflect.ValueOf(list))
+	return nil
+}
+
 func decodeBertString(r io.Reader, val reflect.Value) error {
 	// Read Tag
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
+	err := readFull(r, byte1)
 	if err != nil {
 		return err
 	}
 
 	var strValue string
-	var strType int
+	var strType StringType
 
 	// Compare expected type
 	dataType := int(byte1[0])
 	switch dataType {
 
-	case TagSmallAtomUTF8:
-		data, err := decodeString1(r)
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		atom, err := readAtomBody(r, dataType)
 		if err != nil {
 			return err
 		}
-		strValue = string(data)
-		strType = StringTypeAtom
-
-	case TagDeprecatedAtom, TagAtomUTF8:
-		data, err := decodeString2(r)
-		if err != nil {
-			return err
-		}
-		strValue = string(data)
+		strValue = atom
 		strType = StringTypeAtom
 
 	case TagString:
@@ -303,7 +751,7 @@ func decodeBertString(r io.Reader, val reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		strValue = string(data)
+		strValue = latin1(data)
 		strType = StringTypeString
 
 	case TagBinary:
@@ -322,15 +770,16 @@ func decodeBertString(r io.Reader, val reflect.Value) error {
 		strValue = string(data)
 		strType = StringTypeString
 
+	case TagNil:
+		strType = StringTypeString
+
 	default:
-		return fmt.Errorf("cannot decode %s to bert.String", tagName(dataType))
+		return TagError{Expected: TagBinary, Got: dataType}
 	}
 
-	field := val.FieldByName("Value")
-	field.SetString(strValue)
-	field = val.FieldByName("ErlangType")
-	field.SetInt(int64(strType))
-
+	// Fields of String, in declaration order: Value, ErlangType
+	val.Field(0).SetString(strValue)
+	val.Field(1).SetInt(int64(strType))
 	return nil
 }
 
@@ -339,14 +788,52 @@ func decodeBertString(r io.Reader, val reflect.Value) error {
 func decodeNil(r io.Reader) error {
 	// Read Tag
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
-	if err != nil && err != io.EOF {
+	if err := readFull(r, byte1); err != nil {
 		return err
 	}
 
 	if byte1[0] != byte(TagNil) {
-		return fmt.Errorf("could not find nil: %d", byte1[0])
+		return TagError{Expected: TagNil, Got: int(byte1[0])}
 	}
 
 	return nil
 }
+
+// readFull reads exactly len(buf) bytes, even from readers returning data in small chunks.
+// It is used inside a term, where running out of data means the term is truncated:
+// io.EOF is then reported as io.ErrUnexpectedEOF.
+func readFull(r io.Reader, buf []byte) error {
+	_, err := io.ReadFull(r, buf)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// maxPrealloc caps the number of elements or bytes allocated up front from a length read in a term.
+// Longer data grows as it is decoded, so that a bogus length fails with io.ErrUnexpectedEOF
+// instead of exhausting memory.
+const maxPrealloc = 1 << 16
+
+func preallocSize(length int) int {
+	if length > maxPrealloc {
+		return maxPrealloc
+	}
+	return length
+}
+
+// readBytes reads length bytes, without trusting length for the allocation.
+func readBytes(r io.Reader, length int) ([]byte, error) {
+	if length <= maxPrealloc {
+		data := make([]byte, length)
+		return data, readFull(r, data)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, maxPrealloc))
+	if _, err := io.CopyN(buf, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
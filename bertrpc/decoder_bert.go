@@ -5,51 +5,77 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"reflect"
 )
 
 var ErrReturn = errors.New("function returns 'error'")
 
+// RPCError is the error returned by a BERT-RPC server that could not execute a call:
+// {error, {Type, Code, Class, Detail, Backtrace}}. Type is protocol, server, user or proxy.
+type RPCError struct {
+	Type      string
+	Code      int
+	Class     string
+	Detail    string
+	Backtrace []string
+}
+
+func (e RPCError) Error() string {
+	return fmt.Sprintf("bert-rpc %s error %d: %s: %s", e.Type, e.Code, e.Class, e.Detail)
+}
+
 // A Bert call reply is either:
 // {reply, Result}
-// {error, {Type, Code, Class, Detail, Backtrace}}
+// {error, {Type, Code, Class, Detail, Backtrace}}, returned as an RPCError
 // If we pass an empty struct it means we do not care about the reply and we will not try to decode
 // Erlang return.
+// The whole packet is always read, even when it cannot be decoded, so that r is ready for the next one.
 func DecodeReply(r io.Reader, term interface{}) error {
 	// Guard against nil decoding target  as it does not guide the decoding
 	if term == nil {
 		return fmt.Errorf("target type for decoding cannot be nil")
 	}
 
+	return decodeAnswer(r, term)
+}
+
+// DecodeNoReply reads the {noreply} packet acknowledging a cast. Any other answer is an error:
+// {error, {Type, Code, Class, Detail, Backtrace}} is returned as an RPCError.
+// As with DecodeReply, the whole packet is always read.
+func DecodeNoReply(r io.Reader) error {
+	return decodeAnswer(r, nil)
+}
+
+// decodeAnswer reads an answer packet: a reply decoded into term, or {noreply} when term is nil.
+func decodeAnswer(r io.Reader, term interface{}) error {
 	// 1. Read BERP length
 	byte4 := make([]byte, 4)
-	n, err := r.Read(byte4)
-	if err != nil {
+	if _, err := io.ReadFull(r, byte4); err != nil {
 		return err
 	}
-	if n < 4 {
-		return fmt.Errorf("truncated data")
+	packet := &io.LimitedReader{R: r, N: int64(binary.BigEndian.Uint32(byte4))}
+	err := decodeReplyPacket(packet, term)
+	if _, discardErr := io.Copy(ioutil.Discard, packet); err == nil {
+		err = discardErr
 	}
-	// TODO: Keep track of the length of the data read, to be able to skip to the end on failure.
-	_ = int(binary.BigEndian.Uint32(byte4))
+	return err
+}
 
-	// 2. Read Erlang Term Format "magic byte"
-	byte1 := make([]byte, 1)
-	_, err = r.Read(byte1)
+func decodeReplyPacket(r io.Reader, term interface{}) error {
+	// 2. Read Erlang Term Format "magic byte", and uncompress the reply if needed
+	r, err := readHeader(r)
 	if err != nil {
 		return err
 	}
-	if byte1[0] != byte(TagETFVersion) {
-		// Bad Version tag (aka 'magic number')
-		return fmt.Errorf("incorrect Erlang Term version tag: %d", byte1[0])
-	}
 
 	// 3. Read the reply tuple header
 	length, err := readTupleInfo(r)
 	if err != nil {
 		return err
 	}
-	if length != 2 {
+	// {noreply} only answers casts, and {reply, Result} calls
+	if length != 1 && length != 2 {
 		return errors.New("unexpected bert reply tuple size")
 	}
 
@@ -60,17 +86,22 @@ func DecodeReply(r io.Reader, term interface{}) error {
 	}
 
 	// 5. Decode the reply or the error
-	switch tag {
-	case "reply":
+	switch {
+	case length == 1 && tag == "noreply" && term == nil:
+		return nil
+	case length == 2 && tag == "reply" && term != nil:
 		// Read the result of the function call
 		if err := decodeData(r, term); err != nil {
 			return err
 		}
 
 		return nil
-	case "error":
-		// TODO Decode Bert Error and add test on errors
-		return errors.New("TODO Decode Bert error")
+	case length == 2 && tag == "error":
+		var rpcErr RPCError
+		if err := decodeData(r, &rpcErr); err != nil {
+			return err
+		}
+		return rpcErr
 	default:
 		return fmt.Errorf("incorrect reply tag: %s", tag)
 	}
@@ -79,7 +110,7 @@ func DecodeReply(r io.Reader, term interface{}) error {
 // ============================================================================
 // Decode Erlang Term format into a Go structure
 
-// TODO ignore unexported fields
+// Unexported fields and fields tagged `bert:"-"` are ignored.
 func decodeStruct(r io.Reader, val reflect.Value) error {
 	// If the struct is empty, we assume caller is not interested in the result
 	// and we do not try to decode anything.
@@ -90,10 +121,7 @@ func decodeStruct(r io.Reader, val reflect.Value) error {
 	// Get the first field of the interface we are decoding to, to determine
 	// if we are decoding a target value.
 	// It must be a string and be tagged as erlang:"tag"
-	structType := val.Type()
-	field1 := structType.Field(0)
-	tag, ok := field1.Tag.Lookup("erlang")
-	if ok && tag == "tag" && field1.Type.Kind() == reflect.String {
+	if cachedStructFields(val.Type()).tagged {
 		return decodeTaggedValue(r, val)
 	}
 	return decodeUntaggedStruct(r, val)
@@ -105,69 +133,43 @@ func decodeTaggedValue(r io.Reader, val reflect.Value) error {
 	// If we have something else, we try to decode it in an untagged field.
 	// Read the type of data
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
+	err := readFull(r, byte1)
 	if err != nil {
 		return err
 	}
 
 	switch int(byte1[0]) {
 	// We are directly decoding the tag, return it inside the struct:
-	case TagDeprecatedAtom, TagAtomUTF8, TagSmallAtomUTF8:
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
 		return readTagAtom(r, int(byte1[0]), val)
 	case TagSmallTuple, TagLargeTuple:
 		return readTagTuple(r, int(byte1[0]), val)
 	}
 	// We did not find any field to decode the tag to
-	return fmt.Errorf("decodeTaggedValue could not read atom or taggedTuple")
+	return TagError{Expected: TagSmallTuple, Got: int(byte1[0])}
 }
 
 func readTagAtom(r io.Reader, erlangType int, val reflect.Value) error {
 	switch erlangType {
 	// We are directly decoding the tag, return it inside the struct:
-	case TagDeprecatedAtom, TagAtomUTF8:
-		data, err := decodeString2(r)
-		if err != nil {
-			return err
-		}
-		field1 := val.Field(0)
-		field1.SetString(string(data))
-		return nil
-	case TagSmallAtomUTF8:
-		data, err := decodeString1(r)
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		atom, err := readAtomBody(r, erlangType)
 		if err != nil {
 			return err
 		}
 		field1 := val.Field(0)
-		field1.SetString(string(data))
+		field1.SetString(atom)
 		return nil
 	default:
-		return fmt.Errorf("readTagAtom unexpected mismatch: %d", erlangType)
+		return TagError{Expected: TagSmallAtomUTF8, Got: erlangType}
 	}
 }
 
 func readTagTuple(r io.Reader, erlangType int, val reflect.Value) error {
 	// Get tuple length
-	byte1 := make([]byte, 1)
-	length := 0
-	switch erlangType {
-	case TagSmallTuple:
-		_, err := r.Read(byte1)
-		if err != nil {
-			return err
-		}
-		length = int(byte1[0])
-	case TagLargeTuple:
-		byte4 := make([]byte, 4)
-		n, err := r.Read(byte4)
-		if err != nil {
-			return err
-		}
-		if n < 4 {
-			return fmt.Errorf("truncated data")
-		}
-		length = int(binary.BigEndian.Uint32(byte4))
-	default:
-		return fmt.Errorf("readTagTuple unexpected mismatch: %d", erlangType)
+	length, err := readTupleArity(r, erlangType)
+	if err != nil {
+		return err
 	}
 
 	// An empty tuple cannot have a tag
@@ -176,30 +178,21 @@ func readTagTuple(r io.Reader, erlangType int, val reflect.Value) error {
 	}
 
 	// Extract first field as tag
-	data, err := readAtom(r)
-	tag := string(data)
+	tag, err := readAtom(r)
 	if err != nil {
-		return fmt.Errorf("cannot read atom as first tuple element")
+		return err
 	}
 	field1 := val.Field(0)
 	field1.SetString(tag)
 
-	// Match all others fields against the tag name constraint to decode the fields one by one
-	structType := val.Type()
-	for i := 1; i < structType.NumField(); i++ {
-		field := structType.Field(i)
-		if t, ok := field.Tag.Lookup("erlang"); ok {
-			if t == "tag:"+tag {
-				currField := val.Field(i)
-				if currField.Kind() == reflect.Ptr {
-					currField = currField.Elem()
-				}
-				if currField.CanAddr() {
-					err := decodeData(r, currField.Addr().Interface())
-					if err != nil {
-						return err
-					}
-				}
+	// Decode the fields matching the tag name constraint one by one
+	for _, i := range cachedStructFields(val.Type()).byTag[tag] {
+		// Pointer fields are decoded through their address too, so that nil pointers can be allocated.
+		currField := val.Field(i)
+		if currField.CanAddr() {
+			err := decodeData(r, currField.Addr().Interface())
+			if err != nil {
+				return err
 			}
 		}
 	}
@@ -240,49 +233,39 @@ func readOtherData(r io.Reader, tagName int, val reflect.Value) error {
 func decodeUntaggedStruct(r io.Reader, val reflect.Value) error {
 	// 1. Get the Erlang type of the tuple
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
+	err := readFull(r, byte1)
 	if err != nil {
 		return err
 	}
 
-	length := 0
-	switch int(byte1[0]) {
-	case TagSmallTuple:
-		_, err := r.Read(byte1)
-		if err != nil {
-			return err
-		}
-		length = int(byte1[0])
-	case TagLargeTuple:
-		byte4 := make([]byte, 4)
-		n, err := r.Read(byte4)
+	switch tag := int(byte1[0]); tag {
+	case TagSmallTuple, TagLargeTuple:
+		length, err := readTupleArity(r, tag)
 		if err != nil {
 			return err
 		}
-		if n < 4 {
-			return fmt.Errorf("truncated data")
-		}
-		length = int(binary.BigEndian.Uint32(byte4))
-
+		return decodeStructElts(r, length, val)
+	case TagMap:
+		return decodeMapStruct(r, val)
 	default:
-		return fmt.Errorf("cannot decode type %s to struct %s", tagName(int(byte1[0])), val.Type())
+		return TagError{Expected: TagSmallTuple, Got: tag}
 	}
-
-	return decodeStructElts(r, length, val)
 }
 
 func decodeStructElts(r io.Reader, length int, val reflect.Value) error {
 	// If the tuple does not contain the expected number of fields in our struct
-	if length != val.NumField() {
+	fields := cachedStructFields(val.Type())
+	if fields.err != nil {
+		return fields.err
+	}
+	elems := fields.elems
+	if length != len(elems) {
 		return fmt.Errorf("cannot decode tuple of length %d to struct", length)
 	}
 
 	// For each field, try to decode it recursively
-	for i := 0; i < length; i++ {
+	for _, i := range elems {
 		valueField := val.Field(i)
-		if valueField.Kind() == reflect.Ptr {
-			valueField = valueField.Elem()
-		}
 		if valueField.CanAddr() {
 			err := decodeData(r, valueField.Addr().Interface())
 			if err != nil {
@@ -300,33 +283,34 @@ func decodeStructElts(r io.Reader, length int, val reflect.Value) error {
 func readTupleInfo(r io.Reader) (int, error) {
 	// 1. Read the type of data
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
+	err := readFull(r, byte1)
 	if err != nil {
 		return 0, err
 	}
 
 	// 2. Return
+	return readTupleArity(r, int(byte1[0]))
+}
+
+// readTupleArity reads the length of a tuple whose tag has already been read.
+func readTupleArity(r io.Reader, tag int) (int, error) {
 	tupleLength := 0
-	switch int(byte1[0]) {
+	switch tag {
 	case TagSmallTuple:
-		_, err := r.Read(byte1)
-		if err != nil {
+		byte1 := make([]byte, 1)
+		if err := readFull(r, byte1); err != nil {
 			return 0, err
 		}
 		tupleLength = int(byte1[0])
 	case TagLargeTuple:
 		byte4 := make([]byte, 4)
-		n, err := r.Read(byte4)
-		if err != nil {
+		if err := readFull(r, byte4); err != nil {
 			return 0, err
 		}
-		if n < 4 {
-			return 0, fmt.Errorf("truncated data")
-		}
 		tupleLength = int(binary.BigEndian.Uint32(byte4))
 
 	default:
-		return 0, fmt.Errorf("cannot decode type %d to struct", int(byte1[0]))
+		return 0, TagError{Expected: TagSmallTuple, Got: tag}
 	}
 
 	return tupleLength, nil
@@ -335,26 +319,38 @@ func readTupleInfo(r io.Reader) (int, error) {
 func readAtom(r io.Reader) (string, error) {
 	// Read the type of data
 	byte1 := make([]byte, 1)
-	_, err := r.Read(byte1)
+	err := readFull(r, byte1)
 	if err != nil {
 		return "", err
 	}
 
-	switch int(byte1[0]) {
-	case TagDeprecatedAtom, TagAtomUTF8:
-		data, err := decodeString2(r)
-		if err != nil {
-			return "", err
-		}
-		return string(data), nil
-	case TagSmallAtomUTF8:
-		data, err := decodeString1(r)
-		if err != nil {
-			return "", err
-		}
-		return string(data), nil
+	return readAtomBody(r, int(byte1[0]))
+}
 
+// readAtomBody reads the text of an atom whose tag has already been read.
+// All atoms are read here, so that they can be collected for Decoder.SeenAtoms.
+func readAtomBody(r io.Reader, tag int) (string, error) {
+	var data []byte
+	var err error
+	switch tag {
+	case TagDeprecatedAtom, TagAtomUTF8:
+		data, err = decodeString2(r)
+	case TagSmallAtomUTF8, TagSmallAtom:
+		data, err = decodeString1(r)
 	default:
-		return "", fmt.Errorf("cannot decode type %d as atom", int(byte1[0]))
+		return "", TagError{Expected: TagSmallAtomUTF8, Got: tag}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	atom := string(data)
+	// ATOM_EXT and SMALL_ATOM_EXT, sent by nodes older than R16, are in Latin-1
+	if tag == TagDeprecatedAtom || tag == TagSmallAtom {
+		atom = latin1(data)
+	}
+	if seen := optionsOf(r).seenAtoms; seen != nil {
+		seen[atom] = struct{}{}
 	}
+	return atom, nil
 }
@@ -0,0 +1,186 @@
+package bertrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ============================================================================
+// Decode Erlang terms without a target type
+
+// decodeDynamic decodes the next term, guided only by the Erlang types found in the data:
+// - integers are decoded as int64, or as *big.Int when they do not fit in an int64,
+// - floats are decoded as float64,
+// - binaries and strings are decoded as string,
+// - atoms are decoded as Atom,
+// - pids are decoded as Pid,
+// - references are decoded as Reference,
+// - ports are decoded as Port,
+// - lists are decoded as []interface{}, or as ImproperList when their tail is not nil,
+// - tuples are decoded as Tuple,
+// - maps are decoded as map[interface{}]interface{}.
+func decodeDynamic(r io.Reader) (interface{}, error) {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDynamicBody(r, int(byte1[0]))
+}
+
+// decodeDynamicBody decodes a term whose tag has already been read.
+func decodeDynamicBody(r io.Reader, tag int) (interface{}, error) {
+	switch tag {
+	case TagSmallInteger, TagInteger:
+		return decodeIntBody(r, tag)
+
+	case TagBigInteger, TagLargeBigInteger:
+		n, err := decodeBigIntBody(r, tag)
+		if err != nil {
+			return nil, err
+		}
+		if n.IsInt64() {
+			return n.Int64(), nil
+		}
+		return n, nil
+
+	case TagNewFloat, TagFloat:
+		return decodeFloatBody(r, tag)
+
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		atom, err := readAtomBody(r, tag)
+		if err != nil {
+			return nil, err
+		}
+		return Atom{Value: atom}, nil
+
+	case TagPid, TagNewPid:
+		return decodePidBody(r, tag)
+
+	case TagNewReference, TagNewerReference:
+		return decodeReferenceBody(r, tag)
+
+	case TagPort, TagNewPort:
+		return decodePortBody(r, tag)
+
+	case TagString:
+		data, err := decodeString2(r)
+		if err != nil || !optionsOf(r).stringsAsLists {
+			return latin1(data), err
+		}
+		list := make([]interface{}, len(data))
+		for i, b := range data {
+			list[i] = int64(b)
+		}
+		return list, nil
+
+	case TagBinary:
+		data, err := decodeString4(r)
+		return string(data), err
+
+	case TagNil:
+		return []interface{}{}, nil
+
+	case TagList:
+		return decodeDynamicList(r)
+
+	case TagSmallTuple, TagLargeTuple:
+		length, err := readTupleArity(r, tag)
+		if err != nil {
+			return nil, err
+		}
+		elems := make([]interface{}, 0, preallocSize(length))
+		for i := 0; i < length; i++ {
+			elem, err := decodeDynamic(r)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		return Tuple{Elems: elems}, nil
+
+	case TagMap:
+		entries, err := decodeDynamicMapEntries(r)
+		if err != nil || optionsOf(r).mapsAsEntries {
+			return entries, err
+		}
+		m := make(map[interface{}]interface{}, len(entries))
+		for _, entry := range entries {
+			if entry.Key != nil && !reflect.TypeOf(entry.Key).Comparable() {
+				return nil, fmt.Errorf("cannot use %s as a map key", reflect.TypeOf(entry.Key))
+			}
+			m[entry.Key] = entry.Value
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("cannot decode %s without a target type", TagName(tag))
+}
+
+// decodeDynamicList decodes a list whose tag has already been read. A list ending with a tail other than
+// nil is decoded as an ImproperList.
+func decodeDynamicList(r io.Reader) (interface{}, error) {
+	elems, tail, err := decodeDynamicListElems(r)
+	if err != nil {
+		return nil, err
+	}
+	if tail != nil {
+		return ImproperList{Elems: elems, Tail: tail}, nil
+	}
+	return elems, nil
+}
+
+// decodeDynamicListElems decodes the elements and the tail of a list whose tag has already been read.
+// The tail of a proper list is returned as nil.
+func decodeDynamicListElems(r io.Reader) ([]interface{}, interface{}, error) {
+	// Count:
+	byte4 := make([]byte, 4)
+	if err := readFull(r, byte4); err != nil {
+		return nil, nil, err
+	}
+	count := int(binary.BigEndian.Uint32(byte4))
+
+	list := make([]interface{}, 0, preallocSize(count))
+	for i := 0; i < count; i++ {
+		elem, err := decodeDynamic(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		list = append(list, elem)
+	}
+
+	tail, err := decodeDynamic(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Nil is decoded as an empty list
+	if t, ok := tail.([]interface{}); ok && len(t) == 0 {
+		tail = nil
+	}
+	return list, tail, nil
+}
+
+// Read the arity of a map whose tag has already been read, then its key / value pairs.
+func decodeDynamicMapEntries(r io.Reader) ([]MapEntry, error) {
+	arity, err := readMapArity(r)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]MapEntry, 0, preallocSize(arity))
+	for i := 0; i < arity; i++ {
+		key, err := decodeDynamic(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeDynamic(r)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, MapEntry{Key: key, Value: value})
+	}
+	return entries, nil
+}
@@ -0,0 +1,156 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+// #{<<"b">> => 1, b => 2, 2 => 3, a => 4, 1 => 5}
+// Entries are sorted the way Erlang does it: numbers < atoms < binaries.
+func TestDecodeMapEntries(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 5,
+		109, 0, 0, 0, 1, 98, 97, 1,
+		119, 1, 98, 97, 2,
+		97, 2, 97, 3,
+		119, 1, 97, 97, 4,
+		97, 1, 97, 5}
+	want := []bertrpc.MapEntry{
+		{Key: int64(1), Value: int64(5)},
+		{Key: int64(2), Value: int64(3)},
+		{Key: bertrpc.Atom{Value: "a"}, Value: int64(4)},
+		{Key: bertrpc.Atom{Value: "b"}, Value: int64(2)},
+		{Key: "b", Value: int64(1)},
+	}
+
+	var entries []bertrpc.MapEntry
+	buf := bytes.NewBuffer(input)
+	if err := bertrpc.Decode(buf, &entries); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("incorrect map entries: %v (!= %v)", entries, want)
+	}
+}
+
+// #{[1, 2] => a, [1 | 2] => b}
+// Once the elements of [1 | 2] are exhausted, its tail 2 is compared to [2]: numbers are smaller than lists.
+func TestDecodeMapEntriesImproperListKeys(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 2,
+		108, 0, 0, 0, 2, 97, 1, 97, 2, 106, 119, 1, 97,
+		108, 0, 0, 0, 1, 97, 1, 97, 2, 119, 1, 98}
+	want := []bertrpc.MapEntry{
+		{Key: bertrpc.ImproperList{Elems: bertrpc.L(int64(1)), Tail: int64(2)}, Value: bertrpc.Atom{Value: "b"}},
+		{Key: bertrpc.L(int64(1), int64(2)), Value: bertrpc.Atom{Value: "a"}},
+	}
+
+	var entries []bertrpc.MapEntry
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &entries); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("incorrect map entries: %v (!= %v)", entries, want)
+	}
+}
+
+// Without a concrete target, terms are decoded based on their Erlang type.
+func TestDecodeInterface(t *testing.T) {
+	ok := bertrpc.Atom{Value: "ok"}
+	tests := []struct {
+		name  string
+		input []byte
+		want  interface{}
+	}{
+		{name: "small integer", input: []byte{131, 97, 42}, want: int64(42)},
+		{name: "integer", input: []byte{131, 98, 255, 255, 255, 255}, want: int64(-1)},
+		{name: "float", input: []byte{131, 70, 63, 248, 0, 0, 0, 0, 0, 0}, want: 1.5},
+		{name: "atom", input: []byte{131, 119, 2, 111, 107}, want: ok},
+		{name: "deprecated atom", input: []byte{131, 100, 0, 2, 111, 107}, want: ok},
+		{name: "binary", input: []byte{131, 109, 0, 0, 0, 2, 111, 107}, want: "ok"},
+		{name: "nil", input: []byte{131, 106}, want: []interface{}{}},
+		{name: "list", input: []byte{131, 108, 0, 0, 0, 2, 119, 2, 111, 107, 97, 1, 106}, want: bertrpc.L(ok, int64(1))},
+		{name: "improper list", input: []byte{131, 108, 0, 0, 0, 1, 119, 2, 111, 107, 97, 1},
+			want: bertrpc.ImproperList{Elems: bertrpc.L(ok), Tail: int64(1)}},
+		{name: "nested improper list", input: []byte{131, 108, 0, 0, 0, 1, 108, 0, 0, 0, 1, 97, 1, 97, 2, 106},
+			want: bertrpc.L(bertrpc.ImproperList{Elems: bertrpc.L(int64(1)), Tail: int64(2)})},
+		{name: "tuple", input: []byte{131, 104, 2, 119, 2, 111, 107, 97, 1}, want: bertrpc.T(ok, int64(1))},
+		{name: "map", input: []byte{131, 116, 0, 0, 0, 1, 119, 2, 111, 107, 97, 1},
+			want: map[interface{}]interface{}{ok: int64(1)}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var res interface{}
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &res); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if !reflect.DeepEqual(res, tc.want) {
+				st.Errorf("incorrect decoded value: %#v (!= %#v)", res, tc.want)
+			}
+		})
+	}
+
+	// Interfaces with methods cannot hold any term
+	var s fmt.Stringer
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 119, 2, 111, 107}), &s); err == nil {
+		t.Errorf("decoding into a fmt.Stringer should fail")
+	}
+}
+
+// Integers that do not fit in an int64 are decoded as *big.Int, and sorted with the other numbers.
+func TestDecodeInterfaceBigIntegers(t *testing.T) {
+	two64 := new(big.Int).Lsh(big.NewInt(1), 64)
+	tests := []struct {
+		name  string
+		input []byte
+		want  interface{}
+	}{
+		{name: "small big", input: []byte{131, 110, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, want: two64},
+		{name: "negative small big", input: []byte{131, 110, 9, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1}, want: new(big.Int).Neg(two64)},
+		{name: "large big", input: []byte{131, 111, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, want: two64},
+		{name: "small big fitting in an int64", input: []byte{131, 110, 8, 0, 255, 255, 255, 255, 255, 255, 255, 127},
+			want: int64(math.MaxInt64)},
+		{name: "large big fitting in an int64", input: []byte{131, 111, 0, 0, 0, 1, 0, 5}, want: int64(5)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var res interface{}
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &res); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if want, ok := tc.want.(*big.Int); ok {
+				if n, ok := res.(*big.Int); !ok || n.Cmp(want) != 0 {
+					st.Errorf("incorrect decoded value: %#v (!= %s)", res, want)
+				}
+			} else if res != tc.want {
+				st.Errorf("incorrect decoded value: %#v (!= %#v)", res, tc.want)
+			}
+		})
+	}
+
+	// #{18446744073709551616 => a, 1.5 => b, 1 => c}
+	input := []byte{131, 116, 0, 0, 0, 3, 110, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 119, 1, 97,
+		70, 63, 248, 0, 0, 0, 0, 0, 0, 119, 1, 98, 97, 1, 119, 1, 99}
+	var entries []bertrpc.MapEntry
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &entries); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if len(entries) != 3 || entries[0].Key != int64(1) || entries[1].Key != 1.5 {
+		t.Errorf("incorrect map entries order: %v", entries)
+	} else if n, ok := entries[2].Key.(*big.Int); !ok || n.Cmp(two64) != 0 {
+		t.Errorf("incorrect map entries order: %v", entries)
+	}
+}
@@ -0,0 +1,144 @@
+package bertrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// Decode Erlang maps into Go maps
+
+func decodeMap(r io.Reader, val reflect.Value) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return err
+	}
+	if int(byte1[0]) != TagMap {
+		return TagError{Expected: TagMap, Got: int(byte1[0])}
+	}
+
+	arity, err := readMapArity(r)
+	if err != nil {
+		return err
+	}
+
+	// Allocate the map if needed, so that an empty Erlang map gives a usable empty Go map.
+	if val.IsNil() {
+		val.Set(reflect.MakeMapWithSize(val.Type(), preallocSize(arity)))
+	}
+
+	mapType := val.Type()
+	for i := 0; i < arity; i++ {
+		key := reflect.New(mapType.Key())
+		if err := decodeData(r, key.Interface()); err != nil {
+			return err
+		}
+		value := reflect.New(mapType.Elem())
+		if err := decodeData(r, value.Interface()); err != nil {
+			return err
+		}
+		val.SetMapIndex(key.Elem(), value.Elem())
+	}
+	return nil
+}
+
+// ============================================================================
+// Decode Erlang maps into Go structs
+
+// decodeMapStruct decodes a map whose tag has already been read into a struct.
+// Atom and binary keys are matched the same way, as senders are not always consistent about key types:
+// to the fields tagged `bert:"<key>"`, then case-insensitively to the names of the other exported fields.
+// Keys without a matching field are an error, while fields missing from the map are left untouched.
+func decodeMapStruct(r io.Reader, val reflect.Value) error {
+	if err := cachedStructFields(val.Type()).err; err != nil {
+		return err
+	}
+	arity, err := readMapArity(r)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < arity; i++ {
+		key, err := decodeDynamic(r)
+		if err != nil {
+			return err
+		}
+		var name string
+		switch k := key.(type) {
+		case Atom:
+			name = k.Value
+		case string:
+			name = k
+		default:
+			return fmt.Errorf("cannot decode map key %v to a field of struct %s", key, val.Type())
+		}
+		field := fieldByKey(val, name)
+		if !field.IsValid() {
+			return fmt.Errorf("no field matching key %s in struct %s", name, val.Type())
+		}
+		if err := decodeData(r, field.Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByKey returns the field tagged with key, or else the exported field whose name matches key, ignoring case.
+func fieldByKey(val reflect.Value, key string) reflect.Value {
+	t := val.Type()
+	fields := cachedStructFields(t)
+	if i, ok := fields.byKey[key]; ok {
+		return val.Field(i)
+	}
+	for _, i := range fields.elems {
+		// Fields named by their tag only match their tag
+		f := t.Field(i)
+		if _, named := fields.byKey[f.Tag.Get("bert")]; !named && strings.EqualFold(f.Name, key) {
+			return val.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// ============================================================================
+// Decode Erlang maps into a sorted list of entries
+
+func decodeMapEntries(r io.Reader, val reflect.Value) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return err
+	}
+	if int(byte1[0]) != TagMap {
+		return TagError{Expected: TagMap, Got: int(byte1[0])}
+	}
+
+	entries, err := decodeDynamicMapEntries(r)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareTerms(entries[i].Key, entries[j].Key) < 0
+	})
+	val.Set(reflect.ValueOf(entries).Convert(val.Type()))
+	return nil
+}
+
+// ============================================================================
+// Helpers
+
+// Read the arity of a map whose tag has already been read.
+func readMapArity(r io.Reader) (int, error) {
+	byte4 := make([]byte, 4)
+	if err := readFull(r, byte4); err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(byte4)), nil
+}
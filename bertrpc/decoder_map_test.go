@@ -0,0 +1,220 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+// #{} decodes into an allocated, writable empty map.
+func TestDecodeEmptyMap(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 0}
+
+	var m map[string]int
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &m); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+
+	if m == nil || len(m) != 0 {
+		t.Errorf("incorrect decoded map: %#v", m)
+		return
+	}
+	m["key"] = 1
+}
+
+// #{<<"a">> => 1, <<"b">> => 300}
+func TestDecodeMap(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 2,
+		109, 0, 0, 0, 1, 97, 97, 1,
+		109, 0, 0, 0, 1, 98, 98, 0, 0, 1, 44}
+
+	var m map[string]int
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &m); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+
+	if len(m) != 2 || m["a"] != 1 || m["b"] != 300 {
+		t.Errorf("incorrect decoded map: %#v", m)
+	}
+}
+
+// #{a => 1}: atom keys keep their atom-ness.
+func TestDecodeMapAtomKeys(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 1, 119, 1, 97, 97, 1}
+
+	var m map[bertrpc.Atom]interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &m); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := map[bertrpc.Atom]interface{}{{Value: "a"}: int64(1)}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("incorrect decoded map: %#v (!= %#v)", m, want)
+	}
+
+	// Binary keys cannot be decoded as atoms
+	input = []byte{131, 116, 0, 0, 0, 1, 109, 0, 0, 0, 1, 98, 97, 2}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &m); err == nil {
+		t.Errorf("decoding a binary key into an atom should fail")
+	}
+}
+
+// #{a => [1, 2], b => [3], c => []}, as encoded by term_to_binary: small integer lists are sent as STRING_EXT.
+func TestDecodeMapSliceValues(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 3,
+		100, 0, 1, 97, 107, 0, 2, 1, 2,
+		100, 0, 1, 98, 107, 0, 1, 3,
+		100, 0, 1, 99, 106}
+
+	var m map[string][]int
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &m); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := map[string][]int{"a": {1, 2}, "b": {3}, "c": {}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("incorrect decoded map: %#v (!= %#v)", m, want)
+	}
+	if m["c"] == nil {
+		t.Errorf("empty list should decode to an empty slice")
+	}
+
+	// The same map, with lists sent as LIST_EXT
+	input = []byte{131, 116, 0, 0, 0, 1,
+		100, 0, 1, 97, 108, 0, 0, 0, 2, 97, 1, 98, 0, 0, 1, 0, 106}
+	m = nil
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &m); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(m, map[string][]int{"a": {1, 256}}) {
+		t.Errorf("incorrect decoded map: %#v", m)
+	}
+}
+
+// #{<<"a">> => 1, b => [x]} into map[string]interface{}
+func TestDecodeMapInterfaceValues(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 2,
+		109, 0, 0, 0, 1, 97, 97, 1,
+		109, 0, 0, 0, 1, 98, 108, 0, 0, 0, 1, 119, 1, 120, 106}
+
+	var m map[string]interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &m); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := map[string]interface{}{"a": int64(1), "b": bertrpc.L(bertrpc.Atom{Value: "x"})}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("incorrect decoded map: %#v (!= %#v)", m, want)
+	}
+}
+
+type user struct {
+	Name   string
+	Age    int
+	Admin  bool
+	hidden string
+}
+
+// #{name => <<"joe">>, 'Age' => 42}
+func TestDecodeMapToStruct(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 2,
+		119, 4, 110, 97, 109, 101, 109, 0, 0, 0, 3, 106, 111, 101,
+		119, 3, 65, 103, 101, 97, 42}
+
+	var u user
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &u); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	// Admin is absent from the map and keeps its zero value
+	want := user{Name: "joe", Age: 42}
+	if u != want {
+		t.Errorf("incorrect decoded struct: %#v (!= %#v)", u, want)
+	}
+
+	// #{email => <<>>}: no field matches the key
+	input = []byte{131, 116, 0, 0, 0, 1, 119, 5, 101, 109, 97, 105, 108, 109, 0, 0, 0, 0}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &u); err == nil {
+		t.Errorf("decoding unknown key should fail")
+	}
+
+	// #{hidden => <<>>}: unexported fields are not decoded
+	input = []byte{131, 116, 0, 0, 0, 1, 119, 6, 104, 105, 100, 100, 101, 110, 109, 0, 0, 0, 0}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &u); err == nil {
+		t.Errorf("decoding into unexported field should fail")
+	}
+}
+
+// Binary keys and atom keys match the same fields.
+func TestDecodeMapBinaryKeysToStruct(t *testing.T) {
+	inputs := map[string][]byte{
+		// #{<<"name">> => <<"joe">>, <<"age">> => 42}
+		"binary keys": {131, 116, 0, 0, 0, 2,
+			109, 0, 0, 0, 4, 110, 97, 109, 101, 109, 0, 0, 0, 3, 106, 111, 101,
+			109, 0, 0, 0, 3, 97, 103, 101, 97, 42},
+		// #{name => <<"joe">>, <<"age">> => 42}
+		"mixed keys": {131, 116, 0, 0, 0, 2,
+			119, 4, 110, 97, 109, 101, 109, 0, 0, 0, 3, 106, 111, 101,
+			109, 0, 0, 0, 3, 97, 103, 101, 97, 42},
+	}
+
+	for name, input := range inputs {
+		t.Run(name, func(st *testing.T) {
+			var u user
+			if err := bertrpc.Decode(bytes.NewBuffer(input), &u); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if want := (user{Name: "joe", Age: 42}); u != want {
+				st.Errorf("incorrect decoded struct: %#v (!= %#v)", u, want)
+			}
+		})
+	}
+
+	// Structs encoded with binary keys decode back.
+	data, err := bertrpc.EncodeStructAsMap(profile{Name: "joe", Email: "j@x"}, bertrpc.KeyStyleBinary)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var p profile
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &p); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if want := (profile{Name: "joe", Email: "j@x"}); p != want {
+		t.Errorf("incorrect decoded struct: %#v (!= %#v)", p, want)
+	}
+}
+
+type profile struct {
+	Name  string `bert:"user_name"`
+	Email string
+}
+
+// #{user_name => <<"joe">>, email => <<"j@x">>}
+func TestDecodeMapToTaggedStruct(t *testing.T) {
+	input := []byte{131, 116, 0, 0, 0, 2,
+		119, 9, 117, 115, 101, 114, 95, 110, 97, 109, 101, 109, 0, 0, 0, 3, 106, 111, 101,
+		119, 5, 101, 109, 97, 105, 108, 109, 0, 0, 0, 3, 106, 64, 120}
+
+	var p profile
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &p); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if want := (profile{Name: "joe", Email: "j@x"}); p != want {
+		t.Errorf("incorrect decoded struct: %#v (!= %#v)", p, want)
+	}
+
+	// #{name => <<"joe">>}: a tagged field does not match its Go name
+	input = []byte{131, 116, 0, 0, 0, 1, 119, 4, 110, 97, 109, 101, 109, 0, 0, 0, 3, 106, 111, 101}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &p); err == nil {
+		t.Errorf("decoding a key matching the name of a tagged field should fail")
+	}
+}
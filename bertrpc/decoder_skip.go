@@ -0,0 +1,145 @@
+package bertrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ============================================================================
+// Skip Erlang terms without decoding them
+
+// skipTerm reads the next term without decoding it, only following its lengths and arities.
+// Unlike decodeDynamic, it accepts any term, like maps with tuple or list keys: it is used to capture the
+// bytes of a term that is decoded by someone else.
+func skipTerm(r io.Reader) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return err
+	}
+
+	tag := int(byte1[0])
+	switch tag {
+	case TagSmallInteger:
+		return skipBytes(r, 1)
+	case TagInteger:
+		return skipBytes(r, 4)
+	case TagNewFloat:
+		return skipBytes(r, 8)
+	case TagFloat:
+		return skipBytes(r, 31)
+
+	case TagSmallAtom, TagSmallAtomUTF8:
+		return skipData(r, 1, 0)
+	case TagDeprecatedAtom, TagAtomUTF8, TagString:
+		return skipData(r, 2, 0)
+	case TagBinary:
+		return skipData(r, 4, 0)
+	case TagBitBinary: // The number of bits used in the last byte comes after the length
+		return skipData(r, 4, 1)
+	case TagBigInteger: // The sign comes after the number of digits
+		return skipData(r, 1, 1)
+	case TagLargeBigInteger:
+		return skipData(r, 4, 1)
+
+	case TagNil:
+		return nil
+	case TagSmallTuple, TagLargeTuple:
+		arity, err := readTupleArity(r, tag)
+		if err != nil {
+			return err
+		}
+		return skipTerms(r, arity)
+	case TagList:
+		length, err := readLength(r, 4)
+		if err != nil {
+			return err
+		}
+		// Elements, then the tail
+		return skipTerms(r, length+1)
+	case TagMap:
+		arity, err := readLength(r, 4)
+		if err != nil {
+			return err
+		}
+		return skipTerms(r, 2*arity)
+
+	// Pids, ports and references start with the node atom
+	case TagPid:
+		return skipNodeData(r, 9)
+	case TagNewPid:
+		return skipNodeData(r, 12)
+	case TagPort, TagReference:
+		return skipNodeData(r, 5)
+	case TagNewPort:
+		return skipNodeData(r, 8)
+	case TagV4Port:
+		return skipNodeData(r, 12)
+	case TagNewReference, TagNewerReference:
+		length, err := readLength(r, 2)
+		if err != nil {
+			return err
+		}
+		creationSize := 1
+		if tag == TagNewerReference {
+			creationSize = 4
+		}
+		return skipNodeData(r, int64(creationSize+4*length))
+	}
+
+	return fmt.Errorf("cannot decode %s", TagName(tag))
+}
+
+func skipTerms(r io.Reader, count int) error {
+	for i := 0; i < count; i++ {
+		if err := skipTerm(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipData skips data prefixed by its length on lengthSize bytes, and followed by extra bytes.
+func skipData(r io.Reader, lengthSize int, extra int64) error {
+	length, err := readLength(r, lengthSize)
+	if err != nil {
+		return err
+	}
+	return skipBytes(r, int64(length)+extra)
+}
+
+// skipNodeData skips the node atom of a pid, port or reference, then the size bytes that follow it.
+func skipNodeData(r io.Reader, size int64) error {
+	if err := skipTerm(r); err != nil {
+		return err
+	}
+	return skipBytes(r, size)
+}
+
+func skipBytes(r io.Reader, n int64) error {
+	if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// readLength reads a big endian length on size bytes: 1, 2 or 4.
+func readLength(r io.Reader, size int) (int, error) {
+	data := make([]byte, size)
+	if err := readFull(r, data); err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(data[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(data)), nil
+	default:
+		return int(binary.BigEndian.Uint32(data)), nil
+	}
+}
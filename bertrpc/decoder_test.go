@@ -2,8 +2,14 @@ package bertrpc_test
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/bruceluk/go-erlang/bertrpc"
 )
@@ -44,6 +50,87 @@ func TestDecodeInt(t *testing.T) {
 	}
 }
 
+// SMALL_BIG_EXT values are decoded into int64 as long as they fit, whatever their number of digits.
+func TestDecodeBigInteger(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  int64
+		err   error
+	}{
+		{name: "8 bytes", input: []byte{131, 110, 8, 0, 255, 255, 255, 255, 255, 255, 255, 127}, want: math.MaxInt64},
+		{name: "8 bytes negative", input: []byte{131, 110, 8, 1, 0, 0, 0, 0, 0, 0, 0, 1}, want: -1 << 56},
+		{name: "8 bytes out of range", input: []byte{131, 110, 8, 0, 0, 0, 0, 0, 0, 0, 0, 128}, err: bertrpc.ErrRange},
+		// -9223372036854775808, as encoded by term_to_binary
+		{name: "MinInt64", input: []byte{131, 110, 8, 1, 0, 0, 0, 0, 0, 0, 0, 128}, want: math.MinInt64},
+		{name: "below MinInt64", input: []byte{131, 110, 8, 1, 1, 0, 0, 0, 0, 0, 0, 128}, err: bertrpc.ErrRange},
+		{name: "MinInt64 with leading zeros", input: []byte{131, 110, 9, 1, 0, 0, 0, 0, 0, 0, 0, 128, 0}, want: math.MinInt64},
+		{name: "12 bytes", input: []byte{131, 110, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, err: bertrpc.ErrRange},
+		// Leading zero digits are not canonical, but they do not change the value
+		{name: "12 bytes with leading zeros", input: []byte{131, 110, 12, 0, 42, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, want: 42},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var i int64
+			err := bertrpc.Decode(bytes.NewBuffer(tc.input), &i)
+			if err != tc.err {
+				st.Errorf("unexpected error: %v (!= %v)", err, tc.err)
+				return
+			}
+			if err == nil && i != tc.want {
+				st.Errorf("incorrect decoded value: %d. expected: %d", i, tc.want)
+			}
+		})
+	}
+
+	// Without a target type, math.MinInt64 is an int64 too
+	var term interface{}
+	input := []byte{131, 110, 8, 1, 0, 0, 0, 0, 0, 0, 0, 128}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &term); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if term != int64(math.MinInt64) {
+		t.Errorf("incorrect decoded value: %#v", term)
+	}
+}
+
+func TestDecodeUint(t *testing.T) {
+	var u8 uint8
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 97, 255}), &u8); err != nil || u8 != 255 {
+		t.Errorf("incorrect decoded value: %d (%v)", u8, err)
+	}
+	var u16 uint16
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 98, 0, 0, 1, 0}), &u16); err != nil || u16 != 256 {
+		t.Errorf("incorrect decoded value: %d (%v)", u16, err)
+	}
+	var u32 uint32
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 98, 127, 255, 255, 255}), &u32); err != nil || u32 != math.MaxInt32 {
+		t.Errorf("incorrect decoded value: %d (%v)", u32, err)
+	}
+	var u64 uint64
+	input := []byte{131, 110, 8, 0, 255, 255, 255, 255, 255, 255, 255, 255}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &u64); err != nil || u64 != math.MaxUint64 {
+		t.Errorf("incorrect decoded value: %d (%v)", u64, err)
+	}
+
+	// Negative values and values overflowing the target
+	tests := []struct {
+		input  []byte
+		target interface{}
+	}{
+		{input: []byte{131, 98, 255, 255, 255, 255}, target: new(uint)},
+		{input: []byte{131, 98, 0, 0, 1, 0}, target: new(uint8)},
+		{input: []byte{131, 98, 0, 1, 0, 0}, target: new(uint16)},
+		{input: []byte{131, 110, 5, 0, 0, 0, 0, 0, 1}, target: new(uint32)},
+		{input: []byte{131, 110, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, target: new(uint64)},
+	}
+	for _, tc := range tests {
+		if err := bertrpc.Decode(bytes.NewBuffer(tc.input), tc.target); err != bertrpc.ErrRange {
+			t.Errorf("decoding %v into %T should fail with ErrRange, not %v", tc.input, tc.target, err)
+		}
+	}
+}
+
 // TODO: Implement decode same types to []byte and bert.Atom
 func TestDecodeToString(t *testing.T) {
 	longUTF8 := strings.Repeat("🖖", 64)
@@ -230,3 +317,854 @@ func TestDecodeAtomVsString(t *testing.T) {
 		})
 	}
 }
+
+// Decoding into a bert.Atom tells {ok, foo} apart from {ok, <<"foo">>}.
+func TestDecodeAtom(t *testing.T) {
+	var atom bertrpc.Atom
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 119, 2, 'o', 'k'}), &atom); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if atom.Value != "ok" {
+		t.Errorf("incorrect atom: %#v", atom)
+	}
+
+	// Deprecated ATOM_EXT
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 100, 0, 3, 'f', 'o', 'o'}), &atom); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if atom.Value != "foo" {
+		t.Errorf("incorrect atom: %#v", atom)
+	}
+
+	// <<"ok">>
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 109, 0, 0, 0, 2, 'o', 'k'}), &atom); err == nil {
+		t.Errorf("decoding a binary into an atom should fail")
+	}
+}
+
+// SMALL_ATOM_EXT and ATOM_EXT hold Latin-1 characters: 'café' is sent by older nodes as 115, 4, 99, 97, 102, 233.
+func TestDecodeSmallAtom(t *testing.T) {
+	input := []byte{131, 115, 4, 99, 97, 102, 233}
+
+	var atom bertrpc.Atom
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &atom); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if atom.Value != "café" {
+		t.Errorf("incorrect atom: %#v", atom)
+	}
+
+	var s string
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &s); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if s != "café" {
+		t.Errorf("incorrect decoded string: %q", s)
+	}
+
+	var str bertrpc.String
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &str); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if str.Value != "café" || !str.IsAtom() {
+		t.Errorf("incorrect decoded value: %#v", str)
+	}
+
+	var term interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &term); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if term != (bertrpc.Atom{Value: "café"}) {
+		t.Errorf("incorrect decoded value: %#v", term)
+	}
+
+	// ATOM_EXT is in Latin-1 too: 'é'
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 100, 0, 1, 0xE9}), &atom); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if atom.Value != "é" {
+		t.Errorf("incorrect atom: %#v", atom)
+	}
+}
+
+// hexID implements encoding.BinaryUnmarshaler.
+type hexID struct {
+	Hex string
+}
+
+func (id *hexID) UnmarshalBinary(data []byte) error {
+	id.Hex = fmt.Sprintf("%x", data)
+	return nil
+}
+
+// rawTerm implements both bertrpc.Unmarshaler and encoding.BinaryUnmarshaler.
+type rawTerm struct {
+	Data   []byte
+	Binary bool
+}
+
+func (term *rawTerm) UnmarshalBERT(data []byte) error {
+	term.Data = data
+	return nil
+}
+
+func (term *rawTerm) UnmarshalBinary(data []byte) error {
+	term.Data = data
+	term.Binary = true
+	return nil
+}
+
+func TestDecodeBinaryUnmarshaler(t *testing.T) {
+	input := []byte{131, 109, 0, 0, 0, 4, 222, 173, 190, 239}
+
+	var id hexID
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &id); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if id.Hex != "deadbeef" {
+		t.Errorf("incorrect decoded value: %s", id.Hex)
+	}
+
+	// Decoding an atom into an encoding.BinaryUnmarshaler should fail
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 119, 2, 111, 107}), &id); err == nil {
+		t.Errorf("decoding an atom into a BinaryUnmarshaler should fail")
+	}
+}
+
+// bertrpc.Unmarshaler is preferred over encoding.BinaryUnmarshaler.
+func TestDecodeUnmarshaler(t *testing.T) {
+	input := []byte{131, 104, 2, 119, 2, 111, 107, 109, 0, 0, 0, 1, 42, 97, 1}
+
+	var term rawTerm
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &term); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if term.Binary {
+		t.Errorf("UnmarshalBERT should be called instead of UnmarshalBinary")
+	}
+	// Only the first term is passed to UnmarshalBERT
+	if !bytes.Equal(term.Data, input[:len(input)-2]) {
+		t.Errorf("incorrect raw term: %v", term.Data)
+	}
+
+	// #{{a} => 1, [b] => <<"c">>}: the term is passed as is, even when it has no Go representation.
+	input = []byte{131, 116, 0, 0, 0, 2, 104, 1, 119, 1, 97, 97, 1,
+		108, 0, 0, 0, 1, 119, 1, 98, 106, 109, 0, 0, 0, 1, 99}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &term); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !bytes.Equal(term.Data, input) {
+		t.Errorf("incorrect raw term: %v", term.Data)
+	}
+}
+
+// coord implements bertrpc.TermUnmarshaler for {coord, X, Y} tuples.
+type coord struct {
+	X, Y int64
+}
+
+func (c *coord) FromTerm(term interface{}) error {
+	tuple, ok := term.(bertrpc.Tuple)
+	if !ok || len(tuple.Elems) != 3 || tuple.Elems[0] != (bertrpc.Atom{Value: "coord"}) {
+		return fmt.Errorf("not a coord: %v", term)
+	}
+	x, xOk := tuple.Elems[1].(int64)
+	y, yOk := tuple.Elems[2].(int64)
+	if !xOk || !yOk {
+		return fmt.Errorf("not a coord: %v", term)
+	}
+	c.X, c.Y = x, y
+	return nil
+}
+
+func TestDecodeTermUnmarshaler(t *testing.T) {
+	// [{coord, 1, 300}]
+	input := []byte{131, 108, 0, 0, 0, 1, 104, 3, 119, 5, 99, 111, 111, 114, 100, 97, 1, 98, 0, 0, 1, 44, 106}
+
+	var coords []coord
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &coords); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if want := []coord{{1, 300}}; !reflect.DeepEqual(coords, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", coords, want)
+	}
+
+	// Errors returned by FromTerm are returned by Decode
+	var c coord
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 119, 2, 111, 107}), &c); err == nil {
+		t.Errorf("decoding an atom into a coord should fail")
+	}
+}
+
+// true, false and undefined decode into a *bool as a pointer to true, a pointer to false and nil.
+func TestDecodeOptionalBool(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name  string
+		input []byte
+		want  *bool
+	}{
+		{name: "true", input: []byte{131, 119, 4, 116, 114, 117, 101}, want: &yes},
+		{name: "false", input: []byte{131, 119, 5, 102, 97, 108, 115, 101}, want: &no},
+		{name: "undefined", input: []byte{131, 119, 9, 117, 110, 100, 101, 102, 105, 110, 101, 100}, want: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			// Start from a non nil value, to check that undefined resets it.
+			res := &yes
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &res); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+
+			if (res == nil) != (tc.want == nil) || (res != nil && *res != *tc.want) {
+				st.Errorf("incorrect decoded value: %v (!= %v)", res, tc.want)
+			}
+
+			// Encoding gives back the same term.
+			data, err := bertrpc.Encode(res)
+			if err != nil {
+				st.Error(err)
+			}
+			if !bytes.Equal(data, tc.input) {
+				st.Errorf("incorrect encoded value: %v (!= %v)", data, tc.input)
+			}
+		})
+	}
+}
+
+// Pointer fields are allocated when needed, and the atoms nil and undefined leave them nil.
+func TestDecodePointerFields(t *testing.T) {
+	type reply struct {
+		Status string
+		Data   *string
+	}
+	tests := []struct {
+		name  string
+		input []byte
+		want  *string
+	}{
+		// {ok, nil}
+		{name: "nil", input: []byte{131, 104, 2, 119, 2, 111, 107, 119, 3, 110, 105, 108}},
+		// {ok, undefined}
+		{name: "undefined", input: []byte{131, 104, 2, 119, 2, 111, 107, 119, 9, 117, 110, 100, 101, 102, 105, 110, 101, 100}},
+		// {ok, <<"data">>}
+		{name: "binary", input: []byte{131, 104, 2, 119, 2, 111, 107, 109, 0, 0, 0, 4, 100, 97, 116, 97}, want: strPtr("data")},
+		// {ok, error}: other atoms are decoded into the pointee
+		{name: "atom", input: []byte{131, 104, 2, 119, 2, 111, 107, 119, 5, 101, 114, 114, 111, 114}, want: strPtr("error")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var res reply
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &res); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if res.Status != "ok" || !reflect.DeepEqual(res.Data, tc.want) {
+				st.Errorf("incorrect decoded value: %v (!= %v)", res.Data, tc.want)
+			}
+		})
+	}
+
+	// An existing pointee is reused, and lists of pointers are allocated element by element.
+	data := "old"
+	res := reply{Data: &data}
+	input := []byte{131, 104, 2, 119, 2, 111, 107, 109, 0, 0, 0, 3, 110, 101, 119}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &res); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if res.Data != &data || data != "new" {
+		t.Errorf("incorrect decoded value: %v", res.Data)
+	}
+
+	// nil is a nil *bool too: {ok, nil}
+	var flag struct {
+		Status string
+		Value  *bool
+	}
+	input = []byte{131, 104, 2, 119, 2, 111, 107, 119, 3, 110, 105, 108}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &flag); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if flag.Status != "ok" || flag.Value != nil {
+		t.Errorf("incorrect decoded value: %v", flag.Value)
+	}
+
+	var ints []*int
+	input = []byte{131, 108, 0, 0, 0, 2, 97, 1, 119, 3, 110, 105, 108, 106}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &ints); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if len(ints) != 2 || ints[0] == nil || *ints[0] != 1 || ints[1] != nil {
+		t.Errorf("incorrect decoded value: %v", ints)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// Lists of integers that look like charlists are decoded as integers, not as characters.
+func TestDecodeCharListToInts(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  []int
+	}{
+		{name: "[1, 2, 3] as STRING_EXT", input: []byte{131, 107, 0, 3, 1, 2, 3}, want: []int{1, 2, 3}},
+		{name: "[104, 105, 128406] as LIST_EXT", input: []byte{131, 108, 0, 0, 0, 3, 97, 104, 97, 105, 98, 0, 1, 245, 150, 106},
+			want: []int{104, 105, 128406}},
+		{name: "[]", input: []byte{131, 106}, want: []int{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var res []int
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &res); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if res == nil || !reflect.DeepEqual(res, tc.want) {
+				st.Errorf("incorrect decoded value: %#v (!= %#v)", res, tc.want)
+			}
+		})
+	}
+
+	var runes []rune
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 107, 0, 2, 104, 105}), &runes); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if string(runes) != "hi" {
+		t.Errorf("incorrect decoded value: %#v", runes)
+	}
+}
+
+func TestDecodeList(t *testing.T) {
+	// [1, 300, -1]
+	input := []byte{131, 108, 0, 0, 0, 3, 97, 1, 98, 0, 0, 1, 44, 98, 255, 255, 255, 255, 106}
+	var ints []int
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &ints); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if want := []int{1, 300, -1}; !reflect.DeepEqual(ints, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", ints, want)
+	}
+
+	// [<<"a">>, b, "c"]
+	input = []byte{131, 108, 0, 0, 0, 3, 109, 0, 0, 0, 1, 97, 119, 1, 98, 107, 0, 1, 99, 106}
+	var strs []string
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &strs); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if want := []string{"a", "b", "c"}; !reflect.DeepEqual(strs, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", strs, want)
+	}
+
+	// Elements of a []interface{} are decoded generically
+	var elems []interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &elems); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if want := bertrpc.L("a", bertrpc.Atom{Value: "b"}, "c"); !reflect.DeepEqual(elems, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", elems, want)
+	}
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 107, 0, 2, 1, 2}), &elems); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if want := bertrpc.L(int64(1), int64(2)); !reflect.DeepEqual(elems, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", elems, want)
+	}
+
+	// The list must end with nil: [1 | 2] is not a proper list
+	input = []byte{131, 108, 0, 0, 0, 1, 97, 1, 97, 2}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &ints); err == nil {
+		t.Errorf("decoding an improper list into a slice should fail")
+	}
+}
+
+// Lists of records, like query results, are decoded into slices of structs, also within a struct.
+func TestDecodeListOfStructs(t *testing.T) {
+	type result struct {
+		Tag string
+		N   int
+	}
+	data, err := bertrpc.Encode(bertrpc.L(
+		bertrpc.T(bertrpc.A("ok"), 1), bertrpc.T(bertrpc.A("ok"), 2), bertrpc.T(bertrpc.A("error"), 3)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var results []result
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &results); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := []result{{"ok", 1}, {"ok", 2}, {"error", 3}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("incorrect decoded value: %v (!= %v)", results, want)
+	}
+
+	// {batch, [{ok, 1}, {ok, 2}, {error, 3}]}
+	data, err = bertrpc.Encode(bertrpc.T(bertrpc.A("batch"), bertrpc.L(
+		bertrpc.T(bertrpc.A("ok"), 1), bertrpc.T(bertrpc.A("ok"), 2), bertrpc.T(bertrpc.A("error"), 3))))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var batch struct {
+		Name    string
+		Results []*result
+	}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &batch); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if batch.Name != "batch" || len(batch.Results) != 3 || *batch.Results[2] != want[2] {
+		t.Errorf("incorrect decoded value: %+v", batch)
+	}
+
+	// An element that is not a tuple of the right size fails the whole list
+	data, err = bertrpc.Encode(bertrpc.L(bertrpc.T(bertrpc.A("ok"), 1), bertrpc.T(bertrpc.A("ok"))))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &results); err == nil {
+		t.Errorf("decoding {ok} into a 2-field struct should fail")
+	}
+}
+
+// entry maps the elements of {Key, Version, Value} by index, whatever the field order.
+type entry struct {
+	Value   string `bert:"3"`
+	Key     bertrpc.Atom
+	Version int
+}
+
+func TestDecodeTupleIndexTags(t *testing.T) {
+	// {config, 2, <<"on">>}
+	input := []byte{131, 104, 3, 119, 6, 99, 111, 110, 102, 105, 103, 97, 2, 109, 0, 0, 0, 2, 111, 110}
+
+	var e entry
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &e); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := entry{Key: bertrpc.Atom{Value: "config"}, Version: 2, Value: "on"}
+	if e != want {
+		t.Errorf("incorrect decoded struct: %#v (!= %#v)", e, want)
+	}
+
+	// Encoding uses the same element positions
+	data, err := bertrpc.Encode(e)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(data, input) {
+		t.Errorf("incorrect encoded struct: %v (!= %v)", data, input)
+	}
+
+	// Indexes must be within the tuple
+	var invalid struct {
+		A int `bert:"3"`
+		B int
+	}
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 104, 2, 97, 1, 97, 2}), &invalid); err == nil {
+		t.Errorf("decoding into a struct with an out of range index should fail")
+	}
+}
+
+func BenchmarkDecodeStruct(b *testing.B) {
+	// {1, <<"two">>, three}
+	input := []byte{131, 104, 3, 97, 1, 109, 0, 0, 0, 3, 116, 119, 111, 119, 5, 116, 104, 114, 101, 101}
+	var res struct {
+		One   int
+		Two   string
+		Three bertrpc.String
+	}
+	r := bytes.NewReader(input)
+	for i := 0; i < b.N; i++ {
+		r.Reset(input)
+		if err := bertrpc.Decode(r, &res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeTaggedStruct(b *testing.B) {
+	// {ok, found}
+	input := []byte{131, 104, 2, 100, 0, 2, 111, 107, 100, 0, 5, 102, 111, 117, 110, 100}
+	var res result1
+	r := bytes.NewReader(input)
+	for i := 0; i < b.N; i++ {
+		r.Reset(input)
+		if err := bertrpc.Decode(r, &res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Tuples of more than 255 elements are encoded as LARGE_TUPLE_EXT.
+func TestDecodeLargeTuple(t *testing.T) {
+	elems := make([]interface{}, 300)
+	fields := make([]reflect.StructField, 300)
+	for i := range elems {
+		elems[i] = int64(i)
+		fields[i] = reflect.StructField{Name: fmt.Sprintf("F%d", i), Type: reflect.TypeOf(0)}
+	}
+	data, err := bertrpc.Encode(bertrpc.Tuple{Elems: elems})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if data[1] != bertrpc.TagLargeTuple {
+		t.Errorf("incorrect tag: %d (!= %d)", data[1], bertrpc.TagLargeTuple)
+	}
+
+	var term interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &term); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if want := (bertrpc.Tuple{Elems: elems}); !reflect.DeepEqual(term, want) {
+		t.Errorf("incorrect decoded value: %v", term)
+	}
+
+	// A struct with as many fields
+	s := reflect.New(reflect.StructOf(fields))
+	if err := bertrpc.Decode(bytes.NewBuffer(data), s.Interface()); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	for i := range fields {
+		if n := s.Elem().Field(i).Int(); n != int64(i) {
+			t.Errorf("incorrect decoded field %d: %d", i, n)
+			return
+		}
+	}
+}
+
+func TestDecodeTupleTarget(t *testing.T) {
+	// {ok, 42, "msg"}
+	input := []byte{131, 104, 3, 119, 2, 111, 107, 97, 42, 107, 0, 3, 109, 115, 103}
+	var tuple bertrpc.Tuple
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &tuple); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := bertrpc.T(bertrpc.Atom{Value: "ok"}, int64(42), "msg")
+	if !reflect.DeepEqual(tuple, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", tuple, want)
+	}
+
+	// Tuples can be nested in other targets
+	var results []bertrpc.Tuple
+	// [{ok, 42, "msg"}, {}]
+	input = []byte{131, 108, 0, 0, 0, 2, 104, 3, 119, 2, 111, 107, 97, 42, 107, 0, 3, 109, 115, 103, 104, 0, 106}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &results); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if expected := []bertrpc.Tuple{want, {Elems: []interface{}{}}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", results, expected)
+	}
+
+	// [1]
+	input = []byte{131, 108, 0, 0, 0, 1, 97, 1, 106}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &tuple); err == nil {
+		t.Errorf("decoding a list into a Tuple should fail")
+	}
+}
+
+func TestDecodeFloat(t *testing.T) {
+	legacy := append([]byte{131, 99}, []byte("3.14000000000000012434e+00")...)
+	legacy = append(legacy, make([]byte, 5)...)
+	tests := []struct {
+		name  string
+		input []byte
+		want  float64
+	}{
+		{name: "NEW_FLOAT_EXT", input: []byte{131, 70, 64, 9, 30, 184, 81, 235, 133, 31}, want: 3.14},
+		{name: "FLOAT_EXT", input: legacy, want: 3.14},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var f float64
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &f); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if f != tc.want {
+				st.Errorf("incorrect decoded value: %v (!= %v)", f, tc.want)
+			}
+		})
+	}
+
+	// Float struct fields
+	var res struct {
+		Name  string
+		Value float32
+	}
+	input := []byte{131, 104, 2, 119, 2, 112, 105, 70, 64, 9, 33, 251, 84, 68, 45, 24}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &res); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if res.Name != "pi" || res.Value != float32(math.Pi) {
+		t.Errorf("incorrect decoded value: %#v", res)
+	}
+}
+
+func TestDecodeBool(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  bool
+	}{
+		{name: "true as SMALL_ATOM_UTF8_EXT", input: []byte{131, 119, 4, 116, 114, 117, 101}, want: true},
+		{name: "false as SMALL_ATOM_UTF8_EXT", input: []byte{131, 119, 5, 102, 97, 108, 115, 101}, want: false},
+		{name: "true as ATOM_UTF8_EXT", input: []byte{131, 118, 0, 4, 116, 114, 117, 101}, want: true},
+		{name: "false as ATOM_EXT", input: []byte{131, 100, 0, 5, 102, 97, 108, 115, 101}, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			b := !tc.want
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &b); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if b != tc.want {
+				st.Errorf("incorrect decoded value: %v (!= %v)", b, tc.want)
+			}
+		})
+	}
+
+	// Only true and false can be decoded into a bool
+	var b bool
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 119, 3, 110, 105, 108}), &b); err == nil {
+		t.Errorf("decoding atom nil into a bool should fail")
+	}
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 97, 1}), &b); err == nil {
+		t.Errorf("decoding an integer into a bool should fail")
+	}
+}
+
+// Network readers may deliver data in small chunks: reads must be reassembled.
+func TestDecodeOneByteReader(t *testing.T) {
+	term := bertrpc.T(bertrpc.A("ok"), "a binary", bertrpc.L(1, 300, int64(1)<<40), 1.5)
+	data, err := bertrpc.Encode(term)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var decoded interface{}
+	if err := bertrpc.Decode(iotest.OneByteReader(bytes.NewReader(data)), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := bertrpc.T(bertrpc.Atom{Value: "ok"}, "a binary", bertrpc.L(int64(1), int64(300), int64(1)<<40), 1.5)
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, want)
+	}
+
+	// {ok, Result} into a tagged struct
+	input := []byte{131, 104, 2, 100, 0, 2, 111, 107, 109, 0, 0, 0, 5, 102, 111, 117, 110, 100}
+	var res result1
+	if err := bertrpc.Decode(iotest.OneByteReader(bytes.NewReader(input)), &res); err != nil {
+		t.Errorf("cannot decode function call result: %s", err)
+		return
+	}
+	if res.Tag != "ok" || res.Result != "found" {
+		t.Errorf("incorrect result: %#v", res)
+	}
+}
+
+// Terms of an unexpected Erlang type are reported with a TagError.
+func TestDecodeTagError(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		target interface{}
+		want   bertrpc.TagError
+	}{
+		{name: "binary to int", input: []byte{131, 109, 0, 0, 0, 1, 97}, target: new(int),
+			want: bertrpc.TagError{Expected: bertrpc.TagSmallInteger, Got: bertrpc.TagBinary}},
+		{name: "integer to string", input: []byte{131, 97, 1}, target: new(string),
+			want: bertrpc.TagError{Expected: bertrpc.TagBinary, Got: bertrpc.TagSmallInteger}},
+		{name: "atom to float", input: []byte{131, 119, 2, 111, 107}, target: new(float64),
+			want: bertrpc.TagError{Expected: bertrpc.TagNewFloat, Got: bertrpc.TagSmallAtomUTF8}},
+		{name: "list to Tuple", input: []byte{131, 108, 0, 0, 0, 1, 97, 1, 106}, target: new(bertrpc.Tuple),
+			want: bertrpc.TagError{Expected: bertrpc.TagSmallTuple, Got: bertrpc.TagList}},
+		{name: "binary to Atom", input: []byte{131, 109, 0, 0, 0, 1, 97}, target: new(bertrpc.Atom),
+			want: bertrpc.TagError{Expected: bertrpc.TagSmallAtomUTF8, Got: bertrpc.TagBinary}},
+		{name: "binary to struct", input: []byte{131, 109, 0, 0, 0, 1, 97}, target: new(coordinate),
+			want: bertrpc.TagError{Expected: bertrpc.TagSmallTuple, Got: bertrpc.TagBinary}},
+		{name: "binary to tagged struct", input: []byte{131, 109, 0, 0, 0, 1, 97}, target: new(lookupResult),
+			want: bertrpc.TagError{Expected: bertrpc.TagSmallTuple, Got: bertrpc.TagBinary}},
+		{name: "tuple tagged with a binary to tagged struct", input: []byte{131, 104, 1, 109, 0, 0, 0, 1, 97},
+			target: new(lookupResult), want: bertrpc.TagError{Expected: bertrpc.TagSmallAtomUTF8, Got: bertrpc.TagBinary}},
+		{name: "binary to union", input: []byte{131, 109, 0, 0, 0, 1, 97}, target: new(shape),
+			want: bertrpc.TagError{Expected: bertrpc.TagSmallTuple, Got: bertrpc.TagBinary}},
+		{name: "binary to slice", input: []byte{131, 109, 0, 0, 0, 1, 97}, target: new([]int),
+			want: bertrpc.TagError{Expected: bertrpc.TagList, Got: bertrpc.TagBinary}},
+		{name: "string to string slice", input: []byte{131, 107, 0, 1, 97}, target: new([]string),
+			want: bertrpc.TagError{Expected: bertrpc.TagList, Got: bertrpc.TagString}},
+		{name: "atom to bytes", input: []byte{131, 119, 2, 111, 107}, target: new([]byte),
+			want: bertrpc.TagError{Expected: bertrpc.TagBinary, Got: bertrpc.TagSmallAtomUTF8}},
+		{name: "list to map", input: []byte{131, 106}, target: new(map[string]int),
+			want: bertrpc.TagError{Expected: bertrpc.TagMap, Got: bertrpc.TagNil}},
+		{name: "list to map entries", input: []byte{131, 106}, target: new([]bertrpc.MapEntry),
+			want: bertrpc.TagError{Expected: bertrpc.TagMap, Got: bertrpc.TagNil}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			err := bertrpc.Decode(bytes.NewBuffer(tc.input), tc.target)
+			tagErr, ok := err.(bertrpc.TagError)
+			if !ok {
+				st.Errorf("expected a TagError: %v", err)
+				return
+			}
+			if tagErr != tc.want {
+				st.Errorf("incorrect TagError: %v (!= %v)", tagErr, tc.want)
+			}
+		})
+	}
+}
+
+// Without the version tag, only DecodeNoHeader can decode a term.
+func TestDecodeNoHeader(t *testing.T) {
+	input := []byte{104, 2, 119, 2, 111, 107, 97, 42}
+
+	var tuple bertrpc.Tuple
+	if err := bertrpc.DecodeNoHeader(bytes.NewReader(input), &tuple); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if want := bertrpc.T(bertrpc.Atom{Value: "ok"}, int64(42)); !reflect.DeepEqual(tuple, want) {
+		t.Errorf("incorrect decoded value: %v (!= %v)", tuple, want)
+	}
+
+	if err := bertrpc.Decode(bytes.NewReader(input), &tuple); err == nil {
+		t.Errorf("Decode should require the version tag")
+	}
+	if err := bertrpc.DecodeNoHeader(bytes.NewReader(append([]byte{131}, input...)), &tuple); err == nil {
+		t.Errorf("DecodeNoHeader should not accept the version tag")
+	}
+}
+
+// Data ending in the middle of a term is reported as io.ErrUnexpectedEOF.
+func TestDecodeTruncated(t *testing.T) {
+	data, err := bertrpc.Encode(bertrpc.T("a binary", 1000))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for n := 2; n < len(data); n++ {
+		var decoded interface{}
+		if err := bertrpc.Decode(bytes.NewReader(data[:n]), &decoded); err != io.ErrUnexpectedEOF {
+			t.Errorf("decoding %v should return io.ErrUnexpectedEOF: %v", data[:n], err)
+		}
+	}
+}
+
+// Lengths are not trusted for allocations: a huge length in a truncated term is an unexpected EOF,
+// not an out of memory error.
+func TestDecodeTruncatedHugeLength(t *testing.T) {
+	tests := []struct {
+		input  []byte
+		target interface{}
+	}{
+		{input: []byte{131, 105, 255, 255, 255, 255}, target: new(interface{})},
+		{input: []byte{131, 105, 255, 255, 255, 255}, target: new(bertrpc.Tuple)},
+		{input: []byte{131, 108, 255, 255, 255, 255}, target: new(interface{})},
+		{input: []byte{131, 108, 255, 255, 255, 255, 97, 1}, target: new([]int)},
+		{input: []byte{131, 116, 255, 255, 255, 255}, target: new(interface{})},
+		{input: []byte{131, 116, 255, 255, 255, 255}, target: new(map[string]int)},
+		{input: []byte{131, 116, 255, 255, 255, 255}, target: new([]bertrpc.MapEntry)},
+		{input: []byte{131, 109, 255, 255, 255, 255, 97}, target: new([]byte)},
+		{input: []byte{131, 111, 255, 255, 255, 255, 0, 1}, target: new(big.Int)},
+	}
+	for _, tc := range tests {
+		if err := bertrpc.Decode(bytes.NewReader(tc.input), tc.target); err != io.ErrUnexpectedEOF {
+			t.Errorf("decoding %v into %T should return io.ErrUnexpectedEOF: %v", tc.input, tc.target, err)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	data, err := bertrpc.Marshal(bertrpc.T(bertrpc.A("ok"), "found"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res result1
+	if err := bertrpc.Unmarshal(data, &res); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if res.Tag != "ok" || res.Result != "found" {
+		t.Errorf("incorrect result: %#v", res)
+	}
+
+	// Framing bugs can leave data after the term
+	if err := bertrpc.Unmarshal(append(data, 131, 106), &res); err == nil {
+		t.Errorf("decoding a term followed by trailing bytes should fail")
+	}
+}
+
+// Binaries decode into []byte without any UTF-8 conversion.
+func TestDecodeBytes(t *testing.T) {
+	input := []byte{131, 109, 0, 0, 0, 4, 0, 159, 255, 10}
+
+	var data []byte
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &data); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if want := []byte{0, 159, 255, 10}; !bytes.Equal(data, want) {
+		t.Errorf("incorrect decoded bytes: %v (!= %v)", data, want)
+	}
+
+	// Strings still decode binaries as before
+	var s string
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &s); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if s != "\x00\x9f\xff\n" {
+		t.Errorf("incorrect decoded string: %q", s)
+	}
+
+	// Round trip through Encode
+	payload, err := bertrpc.Encode(bytes.Repeat([]byte{1, 2, 3}, 100))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := bertrpc.Unmarshal(payload, &data); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if !bytes.Equal(data, bytes.Repeat([]byte{1, 2, 3}, 100)) {
+		t.Errorf("incorrect decoded bytes: %v", data)
+	}
+}
+
+// STRING_EXT is decoded into []byte byte by byte, while a string gets the Latin-1 characters.
+func TestDecodeStringExtToBytes(t *testing.T) {
+	want := make([]byte, 256)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	input := append([]byte{131, 107, 1, 0}, want...)
+
+	var data []byte
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &data); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if !bytes.Equal(data, want) {
+		t.Errorf("incorrect decoded bytes: %v (!= %v)", data, want)
+	}
+
+	var s string
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &s); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if r := []rune(s); len(r) != 256 || r[255] != 'ÿ' {
+		t.Errorf("incorrect decoded string: %q", s)
+	}
+}
@@ -1,13 +1,34 @@
 package bertrpc
 
 import (
+	"bufio"
 	"bytes"
+	"encoding"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"reflect"
+	"sort"
+	"time"
 )
 
-// Encode serializes a term as a ETF structure
+// Encode serializes a term as a ETF structure, starting with the version tag (TagETFVersion).
+// Encode, Marshal, EncodeBuffer and EncodeTo all write the version tag: their result must not be prefixed with it.
+//
+// Each value is encoded using, in order of precedence:
+//   - the Marshaler interface,
+//   - the TermMarshaler interface, whose result is encoded in place of the value,
+//   - time.Time, encoded as an os:timestamp() {MegaSecs, Secs, MicroSecs} tuple, although it implements
+//     encoding.BinaryMarshaler,
+//   - the encoding.BinaryMarshaler interface, whose result is encoded as an Erlang binary,
+//   - the union registry, for types registered with RegisterUnion,
+//   - the built-in encoding of the Go type, relying on reflection for slices, maps and sets (maps of empty structs),
+//   - for other pointers, the encoding of the value they point to.
+//
+// A json.RawMessage is transcoded to the equivalent Erlang term.
 func Encode(term interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	if err := EncodeTo(term, &buf); err != nil {
@@ -16,11 +37,50 @@ func Encode(term interface{}) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// EncodeBuffer is like Encode, but returns the buffer the term was encoded to, for callers that keep
+// writing to it. The version tag is written first, like with Encode.
+func EncodeBuffer(term interface{}) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	if err := EncodeTo(term, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Marshal returns the complete wire encoding of a term, starting with the version tag, as
+// expected by binary_to_term. It mirrors encoding/json.Marshal and is the same as Encode.
+func Marshal(term interface{}) ([]byte, error) {
+	return Encode(term)
+}
+
+// NilAtom is the atom that nil and nil pointers are encoded to. The default, nil, is what Elixir uses:
+// set it to "undefined" to follow the Erlang convention instead.
+// Nil errors and nil *bool are always encoded as undefined.
+var NilAtom = "nil"
+
 // Use Erlang External Term Format
 // Reference: http://erlang.org/doc/apps/erts/erl_ext_dist.html
-func EncodeTo(term interface{}, buf *bytes.Buffer) error {
+// EncodeTo writes the version tag (TagETFVersion) before the term, so the caller must not add it.
+// Each call appends a complete term to w: it cannot be used to encode an element of another term.
+// When w is not a *bytes.Buffer, writes go through a bufio.Writer that is flushed before returning,
+// and large binaries are copied to w directly instead of being buffered.
+// A term that fails to encode may then be partially written.
+func EncodeTo(term interface{}, w io.Writer) error {
+	buf, ok := w.(*bytes.Buffer)
+	if ok {
+		return encodeTermTo(term, buf)
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := encodeTermTo(term, bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func encodeTermTo(term interface{}, buf termWriter) error {
 	// Header for External Erlang Term Format
-	buf.Write([]byte{TagETFVersion})
+	buf.WriteByte(TagETFVersion)
 
 	// Encode the data
 	if err := encodePayloadTo(term, buf); err != nil {
@@ -29,10 +89,70 @@ func EncodeTo(term interface{}, buf *bytes.Buffer) error {
 	return nil
 }
 
-func encodePayloadTo(term interface{}, buf *bytes.Buffer) error {
+// termWriter is what terms are encoded to: a *bytes.Buffer or a *bufio.Writer.
+// Write errors of a bufio.Writer are sticky, so they are reported when it is flushed.
+type termWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// Marshaler is the interface implemented by types that can encode themselves as an Erlang term.
+// MarshalBERT returns the External Term Format encoding of the value, including the version tag,
+// as produced by Encode. It takes precedence over TermMarshaler, encoding.BinaryMarshaler and the
+// built-in encoding of the type.
+type Marshaler interface {
+	MarshalBERT() ([]byte, error)
+}
+
+// TermMarshaler is the interface implemented by types that describe their Erlang form with other terms,
+// like Tuple or A. ToTerm returns the value to encode in place of the receiver.
+// It is easier to implement than Marshaler, which has to produce the encoded bytes. A type implementing
+// both is encoded with Marshaler; TermMarshaler takes precedence over encoding.BinaryMarshaler.
+type TermMarshaler interface {
+	ToTerm() interface{}
+}
+
+// encodePayloadTo encodes a term without the version tag, in the order of precedence documented on Encode.
+func encodePayloadTo(term interface{}, buf termWriter) error {
+	// An error interface holding a nil pointer is not nil, but it still means there is no error.
+	// It must not reach the methods of the error type, that may not support nil receivers.
+	if e, ok := term.(error); ok && isNil(e) {
+		return encodeAtom(buf, "undefined")
+	}
+	// Other nil pointers do not reach the marshaling methods either. Nil *bool have their own encoding.
+	if v := reflect.ValueOf(term); v.Kind() == reflect.Ptr && v.IsNil() {
+		if _, ok := term.(*bool); !ok {
+			return encodeAtom(buf, NilAtom)
+		}
+	}
+
+	switch t := term.(type) {
+	case Marshaler:
+		return encodeMarshaler(buf, t)
+	case TermMarshaler:
+		return encodePayloadTo(t.ToTerm(), buf)
+	case time.Time:
+		return encodeTimestamp(buf, t)
+	case encoding.BinaryMarshaler:
+		data, err := t.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return encodeBinary(buf, data)
+	}
+	if term != nil {
+		if tag, ok := unionTag(reflect.TypeOf(term)); ok {
+			return encodeUnion(buf, tag, reflect.ValueOf(term))
+		}
+	}
+
 	var err error
 	switch t := term.(type) {
 
+	case nil:
+		err = encodeAtom(buf, NilAtom)
+
 	case String:
 		if t.ErlangType == StringTypeAtom {
 			err = encodeAtom(buf, t.Value)
@@ -40,9 +160,30 @@ func encodePayloadTo(term interface{}, buf *bytes.Buffer) error {
 			err = encodeString(buf, t.Value)
 		}
 
+	case Atom:
+		err = encodeAtom(buf, t.Value)
+
 	case string:
 		err = encodeString(buf, t)
 
+	case CharList:
+		err = encodeCharList(buf, t.Value)
+
+	case []byte:
+		err = encodeBinary(buf, t)
+	case json.RawMessage:
+		err = encodeJSON(buf, t)
+
+	case bool:
+		err = encodeBool(buf, t)
+	case *bool:
+		// Tri-state boolean: true, false or undefined
+		if t == nil {
+			err = encodeAtom(buf, "undefined")
+		} else {
+			err = encodeBool(buf, *t)
+		}
+
 	case int:
 		err = encodeInt(buf, int64(t))
 	case int8:
@@ -52,9 +193,9 @@ func encodePayloadTo(term interface{}, buf *bytes.Buffer) error {
 	case int32:
 		err = encodeInt(buf, int64(t))
 	case int64:
-		err = encodeInt64(buf, t)
+		err = encodeInt(buf, t)
 	case uint:
-		err = encodeInt(buf, int64(t))
+		err = encodeUint(buf, uint64(t))
 	case uint8:
 		err = encodeInt(buf, int64(t))
 	case uint16:
@@ -62,17 +203,54 @@ func encodePayloadTo(term interface{}, buf *bytes.Buffer) error {
 	case uint32:
 		err = encodeInt(buf, int64(t))
 	case uint64:
-		err = encodeInt64(buf, int64(t))
+		err = encodeUint(buf, t)
+
+	case time.Duration:
+		err = encodeDuration(buf, t)
+
+	case float32:
+		err = encodeFloat(buf, float64(t))
+	case float64:
+		err = encodeFloat(buf, t)
+
+	case *big.Int:
+		err = encodeBigInt(buf, t)
+	case big.Int:
+		err = encodeBigInt(buf, &t)
+	case *big.Rat:
+		err = encodeBigRat(buf, t)
+	case big.Rat:
+		err = encodeBigRat(buf, &t)
+
+	case Pid:
+		err = encodePid(buf, t)
+	case Reference:
+		err = encodeReference(buf, t)
+	case Port:
+		err = encodePort(buf, t)
 
 	case Tuple:
 		err = encodeTuple(buf, t)
 
+	case List:
+		err = encodeList(buf, t)
+	case []interface{}:
+		err = encodeList(buf, t)
+
+	case ImproperList:
+		err = encodeImproperList(buf, t)
+	case []MapEntry:
+		err = encodeMapEntries(buf, t)
+
+	case error:
+		err = encodeError(buf, t)
+
 	default:
 		// Defines how to encode Go pointer types
 		v := reflect.ValueOf(term)
 		switch v.Kind() {
-		case reflect.Slice:
-			// TODO: handle reflect.Array
+		case reflect.Slice, reflect.Array:
+			// Unlike []byte, arrays of bytes are encoded as lists
 			var list []interface{}
 			list, err = makeGenericSlice(term)
 			if err != nil {
@@ -80,6 +258,17 @@ func encodePayloadTo(term interface{}, buf *bytes.Buffer) error {
 				break
 			}
 			err = encodeList(buf, list)
+		case reflect.Ptr:
+			// Nil pointers are handled above, so each level of indirection goes through the whole precedence list.
+			err = encodePayloadTo(v.Elem().Interface(), buf)
+		case reflect.Struct:
+			err = encodeStruct(buf, v)
+		case reflect.Map:
+			if isSet(v.Type()) {
+				err = encodeSet(buf, v)
+				break
+			}
+			err = encodeMap(buf, term)
 		default:
 			err = fmt.Errorf("unhandled type: %v - %v", v.Kind(), v.Type().Name())
 		}
@@ -87,7 +276,20 @@ func encodePayloadTo(term interface{}, buf *bytes.Buffer) error {
 	return err
 }
 
-func encodeAtom(buf *bytes.Buffer, str string) error {
+func encodeMarshaler(buf termWriter, m Marshaler) error {
+	data, err := m.MarshalBERT()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || data[0] != TagETFVersion {
+		return fmt.Errorf("%T.MarshalBERT did not return an Erlang term", m)
+	}
+	// The version tag is only written once, at the beginning of the whole term.
+	buf.Write(data[1:])
+	return nil
+}
+
+func encodeAtom(buf termWriter, str string) error {
 	// Encode atom header
 	if len(str) <= 255 {
 		// Encode small UTF8 atom
@@ -106,7 +308,25 @@ func encodeAtom(buf *bytes.Buffer, str string) error {
 	return nil
 }
 
-func encodeString(buf *bytes.Buffer, str string) error {
+// encodeBinary encodes bytes as a binary, without copying them to a string first.
+func encodeBinary(buf termWriter, data []byte) error {
+	buf.WriteByte(TagBinary)
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// Erlang booleans are the atoms true and false.
+func encodeBool(buf termWriter, b bool) error {
+	if b {
+		return encodeAtom(buf, "true")
+	}
+	return encodeAtom(buf, "false")
+}
+
+func encodeString(buf termWriter, str string) error {
 	buf.WriteByte(TagBinary)
 	if err := binary.Write(buf, binary.BigEndian, uint32(len(str))); err != nil {
 		return err
@@ -115,15 +335,65 @@ func encodeString(buf *bytes.Buffer, str string) error {
 	return nil
 }
 
-func encodeInt(buf *bytes.Buffer, i int64) error {
-	if i >= -0x80000000 && i <= 0x80000000 {
+// encodeCharList encodes a string as a list of code points, like Erlang strings.
+// As with term_to_binary, Latin-1 strings use the compact STRING_EXT, while strings with larger code points
+// use a LIST_EXT of integers.
+func encodeCharList(buf termWriter, s string) error {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		buf.WriteByte(TagNil)
+		return nil
+	}
+
+	latin1 := len(runes) <= math.MaxUint16
+	for _, r := range runes {
+		if r > 255 {
+			latin1 = false
+			break
+		}
+	}
+	if latin1 {
+		buf.WriteByte(TagString)
+		if err := binary.Write(buf, binary.BigEndian, uint16(len(runes))); err != nil {
+			return err
+		}
+		for _, r := range runes {
+			buf.WriteByte(byte(r))
+		}
+		return nil
+	}
+
+	buf.WriteByte(TagList)
+	if err := binary.Write(buf, binary.BigEndian, int32(len(runes))); err != nil {
+		return err
+	}
+	for _, r := range runes {
+		if err := encodeInt(buf, int64(r)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(TagNil)
+	return nil
+}
+
+// encodeInt selects the most compact Erlang integer representation, like term_to_binary does:
+// SMALL_INTEGER_EXT for 0..255, INTEGER_EXT for signed 32 bits values and SMALL_BIG_EXT beyond.
+func encodeInt(buf termWriter, i int64) error {
+	if i >= math.MinInt32 && i <= math.MaxInt32 {
 		return encodeInt32(buf, int32(i))
-	} else {
-		return encodeInt64(buf, i)
 	}
+	return encodeInt64(buf, i)
+}
+
+// Erlang has no unsigned integers: values that do not fit in an int64 can only be encoded as SMALL_BIG_EXT.
+func encodeUint(buf termWriter, i uint64) error {
+	if i > math.MaxInt64 {
+		return encodeSmallBig(buf, 0, i)
+	}
+	return encodeInt(buf, int64(i))
 }
 
-func encodeInt32(buf *bytes.Buffer, i int32) error {
+func encodeInt32(buf termWriter, i int32) error {
 	if i >= 0 && i <= 255 {
 		buf.WriteByte(TagSmallInteger)
 		buf.WriteByte(byte(i))
@@ -136,21 +406,23 @@ func encodeInt32(buf *bytes.Buffer, i int32) error {
 	return nil
 }
 
-func encodeInt64(buf *bytes.Buffer, i int64) error {
-	var sign byte
+// encodeInt64 encodes an integer as a SMALL_BIG_EXT, whatever its value.
+func encodeInt64(buf termWriter, i int64) error {
 	if i >= 0 {
-		sign = 0
-	} else {
-		sign = 1
-		i = -i
+		return encodeSmallBig(buf, 0, uint64(i))
 	}
+	// Negating in unsigned space also works for math.MinInt64, whose magnitude does not fit in an int64.
+	return encodeSmallBig(buf, 1, -uint64(i))
+}
 
-	byteD := make([]byte, 16)
+// encodeSmallBig writes the magnitude of an integer as little-endian digits, prefixed by
+// the number of digits and the sign (0 for positive, 1 for negative).
+func encodeSmallBig(buf termWriter, sign byte, magnitude uint64) error {
+	byteD := make([]byte, 8)
 	var byteCount byte
-	for i > 0 {
-		b := byte(i % 256)
-		byteD[byteCount] = b
-		i = i / 256
+	for magnitude > 0 {
+		byteD[byteCount] = byte(magnitude % 256)
+		magnitude = magnitude / 256
 		byteCount++
 	}
 	buf.WriteByte(TagBigInteger)
@@ -160,7 +432,17 @@ func encodeInt64(buf *bytes.Buffer, i int64) error {
 	return nil
 }
 
-func encodeTuple(buf *bytes.Buffer, tuple Tuple) error {
+// encodeFloat encodes a float as a NEW_FLOAT_EXT, holding the 8 bytes of its IEEE 754 representation.
+// Erlang has no infinity nor NaN: those values are not encoded and return an error.
+func encodeFloat(buf termWriter, f float64) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("cannot encode %v: Erlang floats must be finite", f)
+	}
+	buf.WriteByte(TagNewFloat)
+	return binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+}
+
+func encodeTuple(buf termWriter, tuple Tuple) error {
 	// Tuple header
 	size := len(tuple.Elems)
 	if size <= 255 {
@@ -184,9 +466,38 @@ func encodeTuple(buf *bytes.Buffer, tuple Tuple) error {
 	return nil
 }
 
-func encodeList(buf *bytes.Buffer, list []interface{}) error {
+// encodeStruct encodes a struct the way decodeStruct decodes it. An untagged struct is a tuple of its
+// exported fields, in declaration order unless a `bert:"<n>"` tag pins them to an element, without
+// the fields tagged `bert:"-"`. A tagged struct is its tag atom, or a tuple made of the tag followed by
+// the fields matching it.
+func encodeStruct(buf termWriter, v reflect.Value) error {
+	fields := cachedStructFields(v.Type())
+	if fields.tagged {
+		tag := v.Field(0).String()
+		if len(fields.byTag[tag]) == 0 {
+			return encodeAtom(buf, tag)
+		}
+	}
+
+	t, err := structTuple(v)
+	if err != nil {
+		return err
+	}
+	return encodeTuple(buf, t)
+}
+
+// Errors are encoded as {error, Message}, with the message as a binary.
+func encodeError(buf termWriter, e error) error {
+	return encodeTuple(buf, T(A("error"), e.Error()))
+}
+
+func encodeList(buf termWriter, list []interface{}) error {
 	var err error
-	// TODO: Special case for empty list: v.Len() ? Should not be needed
+	// The empty list is nil, like term_to_binary([]) does
+	if len(list) == 0 {
+		buf.WriteByte(TagNil)
+		return nil
+	}
 
 	// List header
 	buf.WriteByte(TagList)
@@ -205,9 +516,136 @@ func encodeList(buf *bytes.Buffer, list []interface{}) error {
 	return err
 }
 
+// encodeMap encodes a Go map as an Erlang map.
+// Keys are sorted in Erlang term order, so that the encoding of a map does not depend on Go map iteration order.
+func encodeMap(buf termWriter, m interface{}) error {
+	v := reflect.ValueOf(m)
+	keys, err := sortedMapKeys(v)
+	if err != nil {
+		return err
+	}
+
+	// Map header
+	buf.WriteByte(TagMap)
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(keys))); err != nil {
+		return err
+	}
+
+	// Map content
+	for _, k := range keys {
+		buf.Write(k.data)
+		if err := encodePayloadTo(v.MapIndex(k.value).Interface(), buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A mapKey is a key of a Go map, with its encoding.
+type mapKey struct {
+	value reflect.Value
+	data  []byte
+	term  interface{}
+}
+
+// sortedMapKeys encodes the keys of a Go map and sorts them in Erlang term order.
+func sortedMapKeys(v reflect.Value) ([]mapKey, error) {
+	keys := make([]mapKey, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := encodeMapKey(iter.Key().Interface())
+		if err != nil {
+			return nil, err
+		}
+		key.value = iter.Key()
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].less(keys[j])
+	})
+	return keys, nil
+}
+
+func encodeMapKey(key interface{}) (mapKey, error) {
+	var data bytes.Buffer
+	if err := encodePayloadTo(key, &data); err != nil {
+		return mapKey{}, err
+	}
+	// Decode the key back to compare it with the others, whatever its Go type.
+	term, err := decodeDynamic(bytes.NewReader(data.Bytes()))
+	if err != nil {
+		return mapKey{}, err
+	}
+	return mapKey{data: data.Bytes(), term: term}, nil
+}
+
+func (k mapKey) less(other mapKey) bool {
+	if c := compareTerms(k.term, other.term); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(k.data, other.data) < 0
+}
+
+// encodeMapEntries encodes entries as a map, with keys sorted like the keys of Go maps.
+// Keys can be any term, including the tuples and lists a Go map cannot hold, but must be unique.
+func encodeMapEntries(buf termWriter, entries []MapEntry) error {
+	keys := make([]mapKey, len(entries))
+	order := make([]int, len(entries))
+	for i, entry := range entries {
+		key, err := encodeMapKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		keys[i], order[i] = key, i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return keys[order[i]].less(keys[order[j]])
+	})
+
+	// Map header
+	buf.WriteByte(TagMap)
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(entries))); err != nil {
+		return err
+	}
+
+	// Map content
+	for n, i := range order {
+		if n > 0 && bytes.Equal(keys[i].data, keys[order[n-1]].data) {
+			return fmt.Errorf("duplicate map key %v", entries[i].Key)
+		}
+		buf.Write(keys[i].data)
+		if err := encodePayloadTo(entries[i].Value, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeImproperList(buf termWriter, list ImproperList) error {
+	if list.Tail == nil {
+		return encodeList(buf, list.Elems)
+	}
+
+	// List header
+	buf.WriteByte(TagList)
+	if err := binary.Write(buf, binary.BigEndian, int32(len(list.Elems))); err != nil {
+		return err
+	}
+
+	// List content, terminated by the tail instead of nil
+	for _, elem := range list.Elems {
+		if err := encodePayloadTo(elem, buf); err != nil {
+			return err
+		}
+	}
+	return encodePayloadTo(list.Tail, buf)
+}
+
 // ============================================================================
 // Helpers
 
+// makeGenericSlice copies the elements of a slice into a []interface{}. Each element is then encoded
+// on its own, so elements that are slices themselves go through makeGenericSlice in turn.
 func makeGenericSlice(slice interface{}) ([]interface{}, error) {
 	s := reflect.ValueOf(slice)
 	switch s.Kind() {
@@ -224,3 +662,14 @@ func makeGenericSlice(slice interface{}) ([]interface{}, error) {
 			fmt.Errorf("cannot make a generic slice from something that is not a slice: %v", s.Kind())
 	}
 }
+
+// isNil tells if a value that is not a nil interface holds a nil pointer, map, slice, function or channel.
+func isNil(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Interface, reflect.Chan:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,65 @@
+package bertrpc
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// ============================================================================
+// Encode Go structs as Erlang maps
+
+// KeyStyle selects the Erlang type of the keys of a map encoded from a struct.
+type KeyStyle int
+
+const (
+	// KeyStyleBinary encodes keys as binaries, like the maps decoded from JSON by most Erlang libraries.
+	KeyStyleBinary KeyStyle = iota
+	// KeyStyleAtom encodes keys as atoms, like the maps used as records in Erlang and Elixir code.
+	KeyStyleAtom
+)
+
+// EncodeStructAsMap encodes a struct, or a pointer to a struct, as an Erlang map instead of a tuple.
+// Each exported field gives an entry, whose key is the name given by its `bert:"<name>"` tag, or else the
+// name of the field, with the type selected by keys. Fields tagged `bert:"-"` are skipped, as when encoding
+// the struct as a tuple. Field values are encoded as usual: nested structs are still encoded as tuples.
+// Tagged structs have no map form and return an error.
+func EncodeStructAsMap(v interface{}, keys KeyStyle) ([]byte, error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot encode %T as a map: expecting a struct", v)
+	}
+	fields := cachedStructFields(val.Type())
+	if fields.tagged {
+		return nil, fmt.Errorf("cannot encode tagged struct %s as a map", val.Type())
+	}
+	if fields.err != nil {
+		return nil, fields.err
+	}
+
+	names := make(map[int]string, len(fields.byKey))
+	for name, i := range fields.byKey {
+		names[i] = name
+	}
+	m := make(map[interface{}]interface{}, len(fields.elems))
+	for _, i := range fields.elems {
+		name, ok := names[i]
+		if !ok {
+			name = val.Type().Field(i).Name
+		}
+		var key interface{} = name
+		if keys == KeyStyleAtom {
+			key = A(name)
+		}
+		m[key] = val.Field(i).Interface()
+	}
+
+	var buf bytes.Buffer
+	if err := encodeTermTo(m, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
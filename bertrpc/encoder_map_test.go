@@ -0,0 +1,52 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+type member struct {
+	Name   string `bert:"name"`
+	Age    int
+	Secret string `bert:"-"`
+}
+
+func TestEncodeStructAsMap(t *testing.T) {
+	m := member{Name: "jo", Age: 42, Secret: "x"}
+	tests := []struct {
+		name     string
+		keys     bertrpc.KeyStyle
+		expected []byte
+	}{
+		// #{<<"Age">> => 42, <<"name">> => <<"jo">>}
+		{name: "binary keys", keys: bertrpc.KeyStyleBinary, expected: []byte{131, 116, 0, 0, 0, 2,
+			109, 0, 0, 0, 3, 65, 103, 101, 97, 42,
+			109, 0, 0, 0, 4, 110, 97, 109, 101, 109, 0, 0, 0, 2, 106, 111}},
+		// #{'Age' => 42, name => <<"jo">>}
+		{name: "atom keys", keys: bertrpc.KeyStyleAtom, expected: []byte{131, 116, 0, 0, 0, 2,
+			119, 3, 65, 103, 101, 97, 42,
+			119, 4, 110, 97, 109, 101, 109, 0, 0, 0, 2, 106, 111}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			data, err := bertrpc.EncodeStructAsMap(&m, tc.keys)
+			if err != nil {
+				st.Error(err)
+				return
+			}
+			if !bytes.Equal(data, tc.expected) {
+				st.Errorf("EncodeStructAsMap: expected %v, actual %v", tc.expected, data)
+			}
+		})
+	}
+
+	if _, err := bertrpc.EncodeStructAsMap(lookupResult{Tag: "ok"}, bertrpc.KeyStyleAtom); err == nil {
+		t.Errorf("encoding a tagged struct as a map should fail")
+	}
+	if _, err := bertrpc.EncodeStructAsMap(42, bertrpc.KeyStyleAtom); err == nil {
+		t.Errorf("encoding an int as a map should fail")
+	}
+}
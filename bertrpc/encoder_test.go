@@ -2,6 +2,11 @@ package bertrpc_test
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"reflect"
 	"testing"
 
 	"github.com/bruceluk/go-erlang/bertrpc"
@@ -32,6 +37,37 @@ func TestEncodeString(t *testing.T) {
 	}
 }
 
+func TestEncodeCharList(t *testing.T) {
+	var tests = []struct {
+		s        string
+		expected []byte
+	}{
+		{"", []byte{131, 106}},
+		{"hello", []byte{131, 107, 0, 5, 104, 101, 108, 108, 111}},
+		// Latin-1 code points still fit in STRING_EXT
+		{"café", []byte{131, 107, 0, 4, 99, 97, 102, 233}},
+		// Larger code points need a list of integers
+		{"5€", []byte{131, 108, 0, 0, 0, 2, 97, 53, 98, 0, 0, 32, 172, 106}},
+	}
+
+	for _, tt := range tests {
+		data, err := bertrpc.Encode(bertrpc.CharList{Value: tt.s})
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(data, tt.expected) {
+			t.Errorf("EncodeCharList %q: expected %v, actual %v", tt.s, tt.expected, data)
+		}
+
+		var decoded string
+		if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+			t.Errorf("cannot decode Erlang term: %s", err)
+		} else if decoded != tt.s {
+			t.Errorf("incorrect decoded string: %q (!= %q)", decoded, tt.s)
+		}
+	}
+}
+
 func TestEncodeInt(t *testing.T) {
 	var tests = []struct {
 		n        int
@@ -83,6 +119,79 @@ func TestEncodeMiscInt(t *testing.T) {
 	}
 }
 
+// Boundaries of the Erlang integer representations, as produced by term_to_binary.
+func TestEncodeInt64(t *testing.T) {
+	var tests = []struct {
+		n        int64
+		expected []byte
+	}{
+		{0, []byte{bertrpc.TagETFVersion, bertrpc.TagSmallInteger, 0}},
+		{255, []byte{bertrpc.TagETFVersion, bertrpc.TagSmallInteger, 255}},
+		{256, []byte{bertrpc.TagETFVersion, bertrpc.TagInteger, 0, 0, 1, 0}},
+		{-1, []byte{bertrpc.TagETFVersion, bertrpc.TagInteger, 255, 255, 255, 255}},
+		{2147483647, []byte{bertrpc.TagETFVersion, bertrpc.TagInteger, 127, 255, 255, 255}},
+		{2147483648, []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 4, 0, 0, 0, 0, 128}},
+		{-2147483648, []byte{bertrpc.TagETFVersion, bertrpc.TagInteger, 128, 0, 0, 0}},
+		{-2147483649, []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 4, 1, 1, 0, 0, 128}},
+		// A timestamp in milliseconds
+		{1602685200000, []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 6, 0, 128, 102, 123, 39, 117, 1}},
+		{1 << 40, []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 6, 0, 0, 0, 0, 0, 0, 1}},
+		{-1 << 40, []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 6, 1, 0, 0, 0, 0, 0, 1}},
+		{math.MaxInt64, []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 8, 0, 255, 255, 255, 255, 255, 255, 255, 127}},
+		{math.MinInt64, []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 8, 1, 0, 0, 0, 0, 0, 0, 0, 128}},
+	}
+
+	for _, tt := range tests {
+		data, err := bertrpc.Encode(tt.n)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(data, tt.expected) {
+			t.Errorf("EncodeInt64 %d: expected %v, actual %v", tt.n, tt.expected, data)
+		}
+
+		var n int64
+		if err := bertrpc.Decode(bytes.NewBuffer(data), &n); err != nil {
+			t.Errorf("cannot decode Erlang term: %s", err)
+		} else if n != tt.n {
+			t.Errorf("incorrect decoded value: %d (!= %d)", n, tt.n)
+		}
+	}
+}
+
+// Erlang has no unsigned integers: large unsigned values are encoded as positive big integers.
+func TestEncodeUint64(t *testing.T) {
+	var tests = []struct {
+		n        interface{}
+		expected []byte
+	}{
+		{uint64(255), []byte{bertrpc.TagETFVersion, bertrpc.TagSmallInteger, 255}},
+		{uint(2147483647), []byte{bertrpc.TagETFVersion, bertrpc.TagInteger, 127, 255, 255, 255}},
+		{uint(2147483648), []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 4, 0, 0, 0, 0, 128}},
+		{uint64(math.MaxInt64), []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 8, 0, 255, 255, 255, 255, 255, 255, 255, 127}},
+		{uint64(math.MaxInt64 + 1), []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 8, 0, 0, 0, 0, 0, 0, 0, 0, 128}},
+		{uint(math.MaxUint64), []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 8, 0, 255, 255, 255, 255, 255, 255, 255, 255}},
+		{uint64(math.MaxUint64), []byte{bertrpc.TagETFVersion, bertrpc.TagBigInteger, 8, 0, 255, 255, 255, 255, 255, 255, 255, 255}},
+	}
+
+	for _, tt := range tests {
+		data, err := bertrpc.Encode(tt.n)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(data, tt.expected) {
+			t.Errorf("EncodeUint64 %d: expected %v, actual %v", tt.n, tt.expected, data)
+		}
+
+		var n *big.Int
+		if err := bertrpc.Decode(bytes.NewBuffer(data), &n); err != nil {
+			t.Errorf("cannot decode Erlang term: %s", err)
+		} else if n.String() != fmt.Sprint(tt.n) {
+			t.Errorf("incorrect decoded value: %v (!= %v)", n, tt.n)
+		}
+	}
+}
+
 func TestEncodeTuple(t *testing.T) {
 	tuple := bertrpc.T(bertrpc.A("atom"), "string", 42)
 
@@ -137,6 +246,53 @@ func TestEncodeList(t *testing.T) {
 	}
 }
 
+// A List encodes exactly like the []interface{} returned by L.
+func TestEncodeListType(t *testing.T) {
+	list := bertrpc.List{bertrpc.A("atom"), "string", 42, bertrpc.T(1.5), bertrpc.List{}}
+
+	data, err := bertrpc.Encode(list)
+	if err != nil {
+		t.Error(err)
+	}
+	expected, err := bertrpc.Encode(bertrpc.L(bertrpc.A("atom"), "string", 42, bertrpc.T(1.5), bertrpc.L()))
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeListType: expected %v, actual %v", expected, data)
+	}
+	if data[1] != bertrpc.TagList || data[len(data)-2] != bertrpc.TagNil {
+		t.Errorf("EncodeListType: incorrect list encoding %v", data)
+	}
+}
+
+// A List and a Tuple converted from each other encode with the same elements.
+func TestEncodeListTupleConversion(t *testing.T) {
+	list := bertrpc.List{bertrpc.A("ok"), 42}
+
+	data, err := bertrpc.Encode(list)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 108, 0, 0, 0, 2, 119, 2, 111, 107, 97, 42, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeList: expected %v, actual %v", expected, data)
+	}
+
+	data, err = bertrpc.Encode(list.Tuple())
+	if err != nil {
+		t.Error(err)
+	}
+	expected = []byte{131, 104, 2, 119, 2, 111, 107, 97, 42}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeTuple: expected %v, actual %v", expected, data)
+	}
+
+	if back := list.Tuple().List(); !reflect.DeepEqual(back, list) {
+		t.Errorf("incorrect conversion: %#v (!= %#v)", back, list)
+	}
+}
+
 func TestEncodeIntSlice(t *testing.T) {
 	list := []int{1, 2, 3}
 
@@ -152,6 +308,78 @@ func TestEncodeIntSlice(t *testing.T) {
 	}
 }
 
+// Arrays are encoded as lists, including arrays of bytes.
+func TestEncodeArray(t *testing.T) {
+	hash := [4]byte{0xde, 0xad, 0xbe, 0xef}
+
+	data, err := bertrpc.Encode(hash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := []byte{131, 108, 0, 0, 0, 4, 97, 0xde, 97, 0xad, 97, 0xbe, 97, 0xef, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeArray: expected %v, actual %v", expected, data)
+	}
+
+	data, err = bertrpc.Encode([2]string{"a", "b"})
+	if err != nil {
+		t.Error(err)
+	}
+	expected = []byte{131, 108, 0, 0, 0, 2, 109, 0, 0, 0, 1, 97, 109, 0, 0, 0, 1, 98, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeArray: expected %v, actual %v", expected, data)
+	}
+}
+
+// Nested slices are encoded as lists of lists, whatever the type of their elements.
+func TestEncodeNestedSlices(t *testing.T) {
+	tests := []struct {
+		name     string
+		term     interface{}
+		expected []byte
+	}{
+		{name: "[][]int", term: [][]int{{1, 2}, {3}},
+			expected: []byte{131, 108, 0, 0, 0, 2, 108, 0, 0, 0, 2, 97, 1, 97, 2, 106, 108, 0, 0, 0, 1, 97, 3, 106, 106}},
+		{name: "empty inner slices", term: [][]int{nil, {}}, expected: []byte{131, 108, 0, 0, 0, 2, 106, 106, 106}},
+		{name: "[][][]int8", term: [][][]int8{{{1}}},
+			expected: []byte{131, 108, 0, 0, 0, 1, 108, 0, 0, 0, 1, 108, 0, 0, 0, 1, 97, 1, 106, 106, 106}},
+		{name: "[][]string", term: [][]string{{"a"}},
+			expected: []byte{131, 108, 0, 0, 0, 1, 108, 0, 0, 0, 1, 109, 0, 0, 0, 1, 97, 106, 106}},
+		{name: "[]List", term: []bertrpc.List{{1}}, expected: []byte{131, 108, 0, 0, 0, 1, 108, 0, 0, 0, 1, 97, 1, 106, 106}},
+		// Inner []byte are still binaries
+		{name: "[][]byte", term: [][]byte{[]byte("ab")}, expected: []byte{131, 108, 0, 0, 0, 1, 109, 0, 0, 0, 2, 97, 98, 106}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			data, err := bertrpc.Encode(tc.term)
+			if err != nil {
+				st.Errorf("cannot encode %v: %s", tc.term, err)
+				return
+			}
+			if !bytes.Equal(data, tc.expected) {
+				st.Errorf("EncodeNestedSlices: expected %v, actual %v", tc.expected, data)
+			}
+		})
+	}
+
+	data, err := bertrpc.Encode([][]int{{1, 2}, {3}, {}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var decoded [][]int
+	if err := bertrpc.Decode(bytes.NewReader(data), &decoded); err != nil {
+		t.Errorf("cannot decode nested lists: %s", err)
+		return
+	}
+	want := [][]int{{1, 2}, {3}, {}}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %v (!= %v)", decoded, want)
+	}
+}
+
 // Recursive structure: puts a list into a tuple
 func TestEncodeTupleList(t *testing.T) {
 	tuple := bertrpc.T(bertrpc.L(bertrpc.A("atom"), "string", 42))
@@ -167,8 +395,633 @@ func TestEncodeTupleList(t *testing.T) {
 	}
 }
 
+// []byte is encoded as a binary, not as a list of integers.
+func TestEncodeBytes(t *testing.T) {
+	data, err := bertrpc.Encode([]byte{1, 2, 255})
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 109, 0, 0, 0, 3, 1, 2, 255}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeBytes: expected %v, actual %v", expected, data)
+	}
+
+	data, err = bertrpc.Encode([]byte{})
+	if err != nil {
+		t.Error(err)
+	}
+	expected = []byte{131, 109, 0, 0, 0, 0}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeBytes: expected %v, actual %v", expected, data)
+	}
+}
+
+// Compare the size of a 1KB buffer encoded as a binary, and as a list of integers.
+func BenchmarkEncodeBytes(b *testing.B) {
+	payload := bytes.Repeat([]byte{0xff}, 1024)
+	ints := make([]int, len(payload))
+	for i, c := range payload {
+		ints[i] = int(c)
+	}
+
+	for _, bb := range []struct {
+		name string
+		term interface{}
+	}{{"binary", payload}, {"list", ints}} {
+		b.Run(bb.name, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				data, err := bertrpc.Encode(bb.term)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/term")
+		})
+	}
+}
+
+// recordWriter records the size of each write.
+type recordWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+// EncodeBuffer gives the same bytes as Encode, in a buffer that can be written to afterwards.
+func TestEncodeBuffer(t *testing.T) {
+	buf, err := bertrpc.EncodeBuffer(bertrpc.T(bertrpc.A("ok"), 42))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []byte{131, 104, 2, 119, 2, 111, 107, 97, 42}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("EncodeBuffer: expected %v, actual %v", expected, buf.Bytes())
+	}
+
+	// A second term appended to the buffer has its own version tag
+	if err := bertrpc.EncodeTo(bertrpc.A("ok"), buf); err != nil {
+		t.Error(err)
+	}
+	expected = append(expected, 131, 119, 2, 111, 107)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("EncodeBuffer: expected %v, actual %v", expected, buf.Bytes())
+	}
+
+	if _, err := bertrpc.EncodeBuffer(make(chan int)); err == nil {
+		t.Errorf("encoding a channel should fail")
+	}
+}
+
+// Large binaries are streamed to the writer instead of being buffered with the rest of the term.
+func TestEncodeToWriter(t *testing.T) {
+	payload := bytes.Repeat([]byte{1, 2, 3, 4}, 1<<18)
+	term := bertrpc.T(bertrpc.A("data"), payload)
+
+	var w recordWriter
+	if err := bertrpc.EncodeTo(term, &w); err != nil {
+		t.Error(err)
+		return
+	}
+	expected, err := bertrpc.Encode(term)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(w.Bytes(), expected) {
+		t.Errorf("EncodeTo: incorrect encoding of %d bytes", w.Len())
+	}
+
+	// Writes larger than the bufio.Writer default size go around its buffer
+	streamed := false
+	for _, n := range w.writes {
+		if n > 4096 {
+			streamed = true
+		}
+	}
+	if !streamed {
+		t.Errorf("EncodeTo: payload was not written directly: %v", w.writes)
+	}
+}
+
+func BenchmarkEncodeToWriter(b *testing.B) {
+	payload := bytes.Repeat([]byte{0xff}, 8<<20)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	for i := 0; i < b.N; i++ {
+		if err := bertrpc.EncodeTo(payload, ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkBufferString(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_, _ = bertrpc.Encode("test")
 	}
 }
+
+// version implements encoding.BinaryMarshaler.
+type version struct {
+	Major, Minor byte
+}
+
+func (v version) MarshalBinary() ([]byte, error) {
+	return []byte{v.Major, v.Minor}, nil
+}
+
+// status implements both bertrpc.Marshaler and encoding.BinaryMarshaler.
+type status struct {
+	Code string
+}
+
+func (s status) MarshalBERT() ([]byte, error) {
+	return bertrpc.Encode(bertrpc.A(s.Code))
+}
+
+func (s status) MarshalBinary() ([]byte, error) {
+	return []byte(s.Code), nil
+}
+
+func TestEncodeBinaryMarshaler(t *testing.T) {
+	data, err := bertrpc.Encode(bertrpc.T(bertrpc.A("version"), version{1, 2}))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 104, 2, 119, 7, 118, 101, 114, 115, 105, 111, 110, 109, 0, 0, 0, 2, 1, 2}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeBinaryMarshaler: expected %v, actual %v", expected, data)
+	}
+}
+
+// bertrpc.Marshaler is preferred over encoding.BinaryMarshaler.
+func TestEncodeMarshaler(t *testing.T) {
+	data, err := bertrpc.Encode(bertrpc.T(status{"ok"}))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 104, 1, 119, 2, 111, 107}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeMarshaler: expected %v, actual %v", expected, data)
+	}
+}
+
+// point implements bertrpc.TermMarshaler.
+type point struct {
+	X, Y int
+}
+
+func (p point) ToTerm() interface{} {
+	return bertrpc.T(bertrpc.A("point"), p.X, p.Y)
+}
+
+// statusTerm implements both bertrpc.Marshaler and bertrpc.TermMarshaler.
+type statusTerm struct {
+	status
+}
+
+func (s statusTerm) ToTerm() interface{} {
+	return s.Code
+}
+
+func TestEncodeTermMarshaler(t *testing.T) {
+	data, err := bertrpc.Encode(bertrpc.L(point{1, 2}))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 108, 0, 0, 0, 1, 104, 3, 119, 5, 112, 111, 105, 110, 116, 97, 1, 97, 2, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeTermMarshaler: expected %v, actual %v", expected, data)
+	}
+
+	// bertrpc.Marshaler is preferred over bertrpc.TermMarshaler.
+	data, err = bertrpc.Encode(statusTerm{status{"ok"}})
+	if err != nil {
+		t.Error(err)
+	}
+	expected = []byte{131, 119, 2, 111, 107}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeTermMarshaler: expected %v, actual %v", expected, data)
+	}
+}
+
+type callError struct {
+	Reason string
+}
+
+func (e *callError) Error() string {
+	return e.Reason
+}
+
+func TestEncodeError(t *testing.T) {
+	data, err := bertrpc.Encode(bertrpc.T(bertrpc.A("reply"), &callError{"denied"}))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 104, 2, 119, 5, 114, 101, 112, 108, 121, 104, 2, 119, 5, 101, 114, 114, 111, 114,
+		109, 0, 0, 0, 6, 100, 101, 110, 105, 101, 100}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeError: expected %v, actual %v", expected, data)
+	}
+}
+
+// An error interface holding a nil pointer is encoded as undefined, without calling Error() on nil.
+func TestEncodeTypedNilError(t *testing.T) {
+	var e *callError
+	var reply error = e
+
+	data, err := bertrpc.Encode(bertrpc.T(bertrpc.A("reply"), reply))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 104, 2, 119, 5, 114, 101, 112, 108, 121, 119, 9, 117, 110, 100, 101, 102, 105, 110, 101, 100}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeTypedNilError: expected %v, actual %v", expected, data)
+	}
+}
+
+// nil and nil pointers are encoded as NilAtom, without calling the marshaling methods of the pointee type.
+func TestEncodeNil(t *testing.T) {
+	var p *point
+	var i *int
+	data, err := bertrpc.Encode(bertrpc.L(nil, i, p))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 108, 0, 0, 0, 3, 119, 3, 110, 105, 108, 119, 3, 110, 105, 108, 119, 3, 110, 105, 108, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeNil: expected %v, actual %v", expected, data)
+	}
+
+	defer func(atom string) { bertrpc.NilAtom = atom }(bertrpc.NilAtom)
+	bertrpc.NilAtom = "undefined"
+	data, err = bertrpc.Encode(nil)
+	if err != nil {
+		t.Error(err)
+	}
+	expected = []byte{131, 119, 9, 117, 110, 100, 101, 102, 105, 110, 101, 100}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeNil: expected %v, actual %v", expected, data)
+	}
+}
+
+// Pointers are encoded as the value they point to, whatever the level of indirection.
+func TestEncodePointers(t *testing.T) {
+	i := 42
+	pi := &i
+	tuple := bertrpc.T(bertrpc.A("ok"), pi)
+	var nilInt *int
+	value := struct {
+		Count *int
+		Next  **int
+		Point *point
+		Tuple *bertrpc.Tuple
+		Empty **int
+	}{pi, &pi, &point{1, 2}, &tuple, &nilInt}
+
+	data, err := bertrpc.Encode(&value)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected, err := bertrpc.Encode(bertrpc.T(42, 42, bertrpc.T(bertrpc.A("point"), 1, 2),
+		bertrpc.T(bertrpc.A("ok"), 42), bertrpc.A("nil")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodePointers: expected %v, actual %v", expected, data)
+	}
+}
+
+// [1, 2 | <<"tail">>]
+func TestEncodeImproperList(t *testing.T) {
+	list := bertrpc.ImproperList{Elems: bertrpc.L(1, 2), Tail: "tail"}
+
+	data, err := bertrpc.Encode(list)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 108, 0, 0, 0, 2, 97, 1, 97, 2, 109, 0, 0, 0, 4, 116, 97, 105, 108}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeImproperList: expected %v, actual %v", expected, data)
+	}
+
+	var decoded bertrpc.ImproperList
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := bertrpc.ImproperList{Elems: bertrpc.L(int64(1), int64(2)), Tail: "tail"}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, want)
+	}
+}
+
+func TestEncodeMapAtomKeys(t *testing.T) {
+	m := map[bertrpc.Atom]interface{}{
+		{Value: "a"}: int64(1),
+		{Value: "b"}: "two",
+	}
+
+	data, err := bertrpc.Encode(m)
+	if err != nil {
+		t.Error(err)
+	}
+	// #{a => 1, b => <<"two">>}
+	expected := []byte{131, 116, 0, 0, 0, 2, 119, 1, 97, 97, 1, 119, 1, 98, 109, 0, 0, 0, 3, 116, 119, 111}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeMapAtomKeys: expected %v, actual %v", expected, data)
+	}
+
+	var decoded map[bertrpc.Atom]interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, m)
+	}
+}
+
+// String slices are encoded as lists of binaries, not as binaries like []byte.
+func TestEncodeStringSlices(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		expected []byte
+	}{
+		// [<<"x">>, <<"y">>]
+		{name: "strings", input: []string{"x", "y"}, expected: []byte{131, 108, 0, 0, 0, 2, 109, 0, 0, 0, 1, 120, 109, 0, 0, 0, 1, 121, 106}},
+		// []
+		{name: "empty", input: []string{}, expected: []byte{131, 106}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			data, err := bertrpc.Encode(tc.input)
+			if err != nil {
+				st.Error(err)
+			}
+			if !bytes.Equal(data, tc.expected) {
+				st.Errorf("EncodeStringSlices: expected %v, actual %v", tc.expected, data)
+			}
+
+			var decoded []string
+			if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if len(decoded) != len(tc.input) || (len(decoded) > 0 && !reflect.DeepEqual(decoded, tc.input)) {
+				st.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, tc.input)
+			}
+		})
+	}
+}
+
+// String slices in a map are encoded as lists of binaries.
+func TestEncodeMapSliceValues(t *testing.T) {
+	m := map[string][]string{"a": {"x", "y"}, "b": {}}
+
+	data, err := bertrpc.Encode(m)
+	if err != nil {
+		t.Error(err)
+	}
+	// #{<<"a">> => [<<"x">>, <<"y">>], <<"b">> => []}
+	expected := []byte{131, 116, 0, 0, 0, 2,
+		109, 0, 0, 0, 1, 97, 108, 0, 0, 0, 2, 109, 0, 0, 0, 1, 120, 109, 0, 0, 0, 1, 121, 106,
+		109, 0, 0, 0, 1, 98, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeMapSliceValues: expected %v, actual %v", expected, data)
+	}
+
+	var decoded map[string][]string
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, m)
+	}
+}
+
+func TestEncodeFloat(t *testing.T) {
+	var tests = []struct {
+		f        interface{}
+		expected []byte
+	}{
+		// term_to_binary(3.14)
+		{3.14, []byte{131, 70, 64, 9, 30, 184, 81, 235, 133, 31}},
+		{-0.5, []byte{131, 70, 191, 224, 0, 0, 0, 0, 0, 0}},
+		{float32(1.5), []byte{131, 70, 63, 248, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		data, err := bertrpc.Encode(tt.f)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(data, tt.expected) {
+			t.Errorf("EncodeFloat %v: expected %v, actual %v", tt.f, tt.expected, data)
+		}
+	}
+
+	// Erlang rejects infinity and NaN
+	for _, f := range []float64{math.Inf(1), math.Inf(-1), math.NaN()} {
+		if _, err := bertrpc.Encode(f); err == nil {
+			t.Errorf("encoding %v should fail", f)
+		}
+	}
+}
+
+func TestEncodeBool(t *testing.T) {
+	var tests = []struct {
+		term     interface{}
+		expected []byte
+	}{
+		// term_to_binary({ok, true}) on OTP 26, which uses UTF-8 atoms by default
+		{bertrpc.T(bertrpc.A("ok"), true), []byte{131, 104, 2, 119, 2, 111, 107, 119, 4, 116, 114, 117, 101}},
+		{false, []byte{131, 119, 5, 102, 97, 108, 115, 101}},
+	}
+
+	for _, tt := range tests {
+		data, err := bertrpc.Encode(tt.term)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(data, tt.expected) {
+			t.Errorf("EncodeBool %v: expected %v, actual %v", tt.term, tt.expected, data)
+		}
+	}
+}
+
+// Payloads for gen_servers expecting maps: the map is checked by decoding it back.
+func TestEncodeMapRoundTrip(t *testing.T) {
+	m := map[string]interface{}{
+		"name":  "ejabberd",
+		"port":  int64(5222),
+		"tls":   true,
+		"hosts": bertrpc.L("localhost", "example.com"),
+		"opts":  map[string]interface{}{"timeout": int64(3000)},
+	}
+
+	data, err := bertrpc.Encode(m)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var decoded map[string]interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := map[string]interface{}{
+		"name":  "ejabberd",
+		"port":  int64(5222),
+		"tls":   bertrpc.Atom{Value: "true"},
+		"hosts": bertrpc.L("localhost", "example.com"),
+		"opts":  map[interface{}]interface{}{"timeout": int64(3000)},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, want)
+	}
+}
+
+// A []MapEntry is encoded as a map sorted by key, and can hold keys a Go map cannot.
+func TestEncodeMapEntries(t *testing.T) {
+	entries := []bertrpc.MapEntry{
+		{Key: bertrpc.T(1), Value: 2},
+		{Key: bertrpc.A("a"), Value: 1},
+	}
+	data, err := bertrpc.Encode(entries)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// #{a => 1, {1} => 2}
+	expected := []byte{131, 116, 0, 0, 0, 2, 119, 1, 97, 97, 1, 104, 1, 97, 1, 97, 2}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeMapEntries: expected %v, actual %v", expected, data)
+	}
+
+	var decoded []bertrpc.MapEntry
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := []bertrpc.MapEntry{
+		{Key: bertrpc.Atom{Value: "a"}, Value: int64(1)},
+		{Key: bertrpc.T(int64(1)), Value: int64(2)},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, want)
+	}
+
+	if _, err := bertrpc.Encode(append(entries, bertrpc.MapEntry{Key: bertrpc.T(1), Value: 3})); err == nil {
+		t.Errorf("encoding a map with duplicate keys should fail")
+	}
+}
+
+// Marshal returns the version tag followed by the term, like EncodeTo writes it.
+func TestMarshal(t *testing.T) {
+	data, err := bertrpc.Marshal(bertrpc.T(bertrpc.A("ok"), 1))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{131, 104, 2, 119, 2, 111, 107, 97, 1}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Marshal: expected %v, actual %v", expected, data)
+	}
+
+	var buf bytes.Buffer
+	if err := bertrpc.EncodeTo(bertrpc.T(bertrpc.A("ok"), 1), &buf); err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("EncodeTo: expected %v, actual %v", expected, buf.Bytes())
+	}
+}
+
+type account struct {
+	ID     int
+	Name   string
+	Roles  []bertrpc.Atom
+	Cache  string `bert:"-"`
+	secret string
+}
+
+// Structs are encoded as tuples of their exported fields, and decode back to the same value.
+func TestEncodeStruct(t *testing.T) {
+	acc := account{ID: 1, Name: "joe", Roles: []bertrpc.Atom{{Value: "admin"}}, Cache: "x", secret: "y"}
+
+	data, err := bertrpc.Encode(acc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// {1, <<"joe">>, [admin]}
+	expected := []byte{131, 104, 3, 97, 1, 109, 0, 0, 0, 3, 106, 111, 101, 108, 0, 0, 0, 1, 119, 5, 97, 100, 109, 105, 110, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeStruct: expected %v, actual %v", expected, data)
+	}
+
+	var decoded account
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := account{ID: 1, Name: "joe", Roles: []bertrpc.Atom{{Value: "admin"}}}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, want)
+	}
+}
+
+type lookupResult struct {
+	Tag    string       `erlang:"tag"`
+	Value  int          `erlang:"tag:ok"`
+	Reason bertrpc.Atom `erlang:"tag:error"`
+}
+
+// Tagged structs are encoded as their tag, alone or followed by the fields matching it.
+func TestEncodeTaggedStruct(t *testing.T) {
+	tests := []struct {
+		name     string
+		term     lookupResult
+		expected []byte
+	}{
+		{name: "{ok, 42}", term: lookupResult{Tag: "ok", Value: 42},
+			expected: []byte{131, 104, 2, 119, 2, 111, 107, 97, 42}},
+		{name: "{error, not_found}", term: lookupResult{Tag: "error", Reason: bertrpc.Atom{Value: "not_found"}},
+			expected: []byte{131, 104, 2, 119, 5, 101, 114, 114, 111, 114, 119, 9, 110, 111, 116, 95, 102, 111, 117, 110, 100}},
+		{name: "undefined", term: lookupResult{Tag: "undefined"},
+			expected: []byte{131, 119, 9, 117, 110, 100, 101, 102, 105, 110, 101, 100}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			data, err := bertrpc.Encode(tc.term)
+			if err != nil {
+				st.Error(err)
+			}
+			if !bytes.Equal(data, tc.expected) {
+				st.Errorf("EncodeTaggedStruct: expected %v, actual %v", tc.expected, data)
+			}
+
+			var decoded lookupResult
+			if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if decoded != tc.term {
+				st.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, tc.term)
+			}
+		})
+	}
+}
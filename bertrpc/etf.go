@@ -1,54 +1,104 @@
 package bertrpc
 
-// Supported ETF types
+import (
+	"fmt"
+	"strconv"
+)
+
+// Tags of the External Term Format. BIT_BINARY_EXT, ATOM_CACHE_REF, REFERENCE_EXT, NEW_FUN_EXT,
+// EXPORT_EXT, FUN_EXT, V4_PORT_EXT and LOCAL_EXT terms cannot be decoded by this package.
 const (
-	TagSmallInteger   = 97
-	TagInteger        = 98
-	TagDeprecatedAtom = 100
-	TagSmallTuple     = 104
-	TagLargeTuple     = 105
-	TagNil            = 106
-	TagString         = 107
-	TagList           = 108
-	TagBinary         = 109
-	TagBigInteger     = 110
-	TagAtomUTF8       = 118
-	TagSmallAtomUTF8  = 119
-	TagETFVersion     = 131
+	TagNewFloat        = 70
+	TagBitBinary       = 77
+	TagCompressed      = 80
+	TagAtomCacheRef    = 82
+	TagNewPid          = 88
+	TagNewPort         = 89
+	TagNewerReference  = 90
+	TagSmallInteger    = 97
+	TagInteger         = 98
+	TagFloat           = 99
+	TagDeprecatedAtom  = 100
+	TagReference       = 101
+	TagPort            = 102
+	TagPid             = 103
+	TagSmallTuple      = 104
+	TagLargeTuple      = 105
+	TagNil             = 106
+	TagString          = 107
+	TagList            = 108
+	TagBinary          = 109
+	TagBigInteger      = 110
+	TagLargeBigInteger = 111
+	TagNewFun          = 112
+	TagExport          = 113
+	TagNewReference    = 114
+	TagSmallAtom       = 115
+	TagMap             = 116
+	TagFun             = 117
+	TagAtomUTF8        = 118
+	TagSmallAtomUTF8   = 119
+	TagV4Port          = 120
+	TagLocal           = 121
+	TagETFVersion      = 131
 )
 
-// tagName convert a tag ID to its human readable tag name.
-func tagName(tag int) string {
-	switch tag {
-	case TagSmallInteger:
-		return "SmallInteger"
-	case TagInteger:
-		return "Integer"
-	case TagDeprecatedAtom:
-		return "DeprecatedAtom"
-	case TagSmallTuple:
-		return "SmallTuple"
-	case TagLargeTuple:
-		return "LargeTuple"
-	case TagNil:
-		return "Nil"
-	case TagString:
-		return "String"
-	case TagList:
-		return "List"
-	case TagBinary:
-		return "Binary"
-	case TagBigInteger:
-		return "BigInteger"
-	case TagAtomUTF8:
-		return "AtomUTF8"
-	case TagSmallAtomUTF8:
-		return "SmallAtomUTF"
-	case TagETFVersion:
-		return "VersionTag"
-	default:
-		return string(tag)
+// tagNames holds the name of every tag of the External Term Format, as the Erlang documentation spells it.
+// Some of them are not supported by this package, but they still show up in errors when they are met.
+var tagNames = map[int]string{
+	TagNewFloat:        "NEW_FLOAT_EXT",
+	TagBitBinary:       "BIT_BINARY_EXT",
+	TagCompressed:      "COMPRESSED",
+	TagAtomCacheRef:    "ATOM_CACHE_REF",
+	TagNewPid:          "NEW_PID_EXT",
+	TagNewPort:         "NEW_PORT_EXT",
+	TagNewerReference:  "NEWER_REFERENCE_EXT",
+	TagSmallInteger:    "SMALL_INTEGER_EXT",
+	TagInteger:         "INTEGER_EXT",
+	TagFloat:           "FLOAT_EXT",
+	TagDeprecatedAtom:  "ATOM_EXT",
+	TagReference:       "REFERENCE_EXT",
+	TagPort:            "PORT_EXT",
+	TagPid:             "PID_EXT",
+	TagSmallTuple:      "SMALL_TUPLE_EXT",
+	TagLargeTuple:      "LARGE_TUPLE_EXT",
+	TagNil:             "NIL_EXT",
+	TagString:          "STRING_EXT",
+	TagList:            "LIST_EXT",
+	TagBinary:          "BINARY_EXT",
+	TagBigInteger:      "SMALL_BIG_EXT",
+	TagLargeBigInteger: "LARGE_BIG_EXT",
+	TagNewFun:          "NEW_FUN_EXT",
+	TagExport:          "EXPORT_EXT",
+	TagNewReference:    "NEW_REFERENCE_EXT",
+	TagSmallAtom:       "SMALL_ATOM_EXT",
+	TagMap:             "MAP_EXT",
+	TagFun:             "FUN_EXT",
+	TagAtomUTF8:        "ATOM_UTF8_EXT",
+	TagSmallAtomUTF8:   "SMALL_ATOM_UTF8_EXT",
+	TagV4Port:          "V4_PORT_EXT",
+	TagLocal:           "LOCAL_EXT",
+	TagETFVersion:      "VERSION_MAGIC",
+}
+
+// TagName returns the name of an External Term Format tag, like BINARY_EXT for TagBinary.
+// Unknown tags are returned as their number.
+func TagName(tag int) string {
+	if name, ok := tagNames[tag]; ok {
+		return name
 	}
+	return strconv.Itoa(tag)
+}
+
+// TagError is returned when a term does not have the Erlang type expected to decode it.
+// Expected is the tag of the main encoding of that type, even when several encodings are accepted:
+// SMALL_INTEGER_EXT for integers, for instance.
+type TagError struct {
+	Expected, Got int
+}
+
+func (e TagError) Error() string {
+	return fmt.Sprintf("unexpected %s, expected %s", TagName(e.Got), TagName(e.Expected))
 }
 
 // ============================================================================
@@ -78,6 +128,17 @@ func (str String) IsAtom() bool {
 	return str.ErlangType == StringTypeAtom
 }
 
+// Atom is an Erlang atom.
+// Atoms are returned as Atom when decoding a term without a target type, so that they can be told apart
+// from binaries.
+type Atom struct {
+	Value string
+}
+
+func (atom Atom) String() string {
+	return atom.Value
+}
+
 // ============================================================================
 // List / Collection types
 
@@ -85,8 +146,34 @@ type Tuple struct {
 	Elems []interface{}
 }
 
+// List returns the elements of the tuple as a list.
+func (t Tuple) List() List {
+	return List(t.Elems)
+}
+
+// List is an Erlang list. It encodes like a plain []interface{}, which is what L returns.
 type List []interface{}
 
+// Tuple returns the elements of the list as a tuple.
+func (l List) Tuple() Tuple {
+	return Tuple{Elems: l}
+}
+
+// ImproperList is an Erlang list whose tail is not the empty list, like [a, b | c].
+// A nil Tail stands for a proper list.
+type ImproperList struct {
+	Elems []interface{}
+	Tail  interface{}
+}
+
+// MapEntry is a key / value pair of an Erlang map.
+// Decoding a map into a []MapEntry returns its entries sorted by key, in Erlang term order, and a
+// []MapEntry is encoded as a map. Unlike a Go map, it can hold keys like tuples and lists.
+type MapEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
 // Charlist is a wrapper structure to support Erlang charlist in encoding.
 // Charlist is only used in encoding. On decoding, charlists are always decoded
 // as strings.
@@ -0,0 +1,33 @@
+package bertrpc_test
+
+import (
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestTagName(t *testing.T) {
+	tests := []struct {
+		tag  int
+		want string
+	}{
+		{tag: bertrpc.TagBinary, want: "BINARY_EXT"},
+		{tag: bertrpc.TagSmallAtomUTF8, want: "SMALL_ATOM_UTF8_EXT"},
+		{tag: bertrpc.TagBigInteger, want: "SMALL_BIG_EXT"},
+		{tag: bertrpc.TagNewerReference, want: "NEWER_REFERENCE_EXT"},
+		{tag: 77, want: "BIT_BINARY_EXT"},
+		{tag: 113, want: "EXPORT_EXT"},
+		{tag: 42, want: "42"},
+	}
+
+	for _, tc := range tests {
+		if name := bertrpc.TagName(tc.tag); name != tc.want {
+			t.Errorf("TagName(%d): expected %s, actual %s", tc.tag, tc.want, name)
+		}
+	}
+
+	err := bertrpc.TagError{Expected: bertrpc.TagNil, Got: bertrpc.TagList}
+	if want := "unexpected LIST_EXT, expected NIL_EXT"; err.Error() != want {
+		t.Errorf("TagError: expected %q, actual %q", want, err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+package bertrpc
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// structFields holds what the encoder and the decoder need to know about a struct type.
+// It is computed once per type, so that decoding many values of the same type
+// does not have to inspect struct tags again.
+type structFields struct {
+	// tagged is true when the first field is a string tagged `erlang:"tag"`,
+	// meaning the struct is decoded as a tagged value.
+	tagged bool
+	// byTag lists, for each tag, the index of the fields tagged `erlang:"tag:<tag>"`.
+	byTag map[string][]int
+	// elems lists, for each element of an untagged struct tuple, the index of the field holding it.
+	// A field tagged `bert:"<n>"` holds the nth element, counting from 1 like element/2, and the other
+	// exported fields hold the remaining elements in declaration order. Unexported fields and fields
+	// tagged `bert:"-"` are skipped.
+	elems []int
+	// byKey gives the index of the fields tagged `bert:"<name>"`, matched by map key.
+	byKey map[string]int
+	// err reports invalid `bert` tags.
+	err error
+}
+
+var structFieldsCache sync.Map // map[reflect.Type]*structFields
+
+func cachedStructFields(t reflect.Type) *structFields {
+	if f, ok := structFieldsCache.Load(t); ok {
+		return f.(*structFields)
+	}
+
+	fields := &structFields{byTag: make(map[string][]int), byKey: make(map[string]int)}
+	if t.NumField() > 0 {
+		field1 := t.Field(0)
+		tag, ok := field1.Tag.Lookup("erlang")
+		fields.tagged = ok && tag == "tag" && field1.Type.Kind() == reflect.String
+	}
+	for i := 1; i < t.NumField(); i++ {
+		if tag, ok := t.Field(i).Tag.Lookup("erlang"); ok && strings.HasPrefix(tag, "tag:") {
+			name := strings.TrimPrefix(tag, "tag:")
+			fields.byTag[name] = append(fields.byTag[name], i)
+		}
+	}
+	fields.elems, fields.err = tupleFields(t, fields.byKey)
+
+	f, _ := structFieldsCache.LoadOrStore(t, fields)
+	return f.(*structFields)
+}
+
+// tupleFields places the exported fields of a struct in the elements of a tuple, and records the
+// fields named by their `bert` tag in byKey.
+func tupleFields(t reflect.Type, byKey map[string]int) ([]int, error) {
+	pinned := make(map[int]int)
+	var others []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("bert")
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		n, err := strconv.Atoi(tag)
+		switch {
+		case tag == "":
+			others = append(others, i)
+		case err == nil:
+			if _, ok := pinned[n]; ok {
+				return nil, fmt.Errorf("%s: several fields are tagged bert:\"%d\"", t, n)
+			}
+			pinned[n] = i
+		default:
+			byKey[tag] = i
+			others = append(others, i)
+		}
+	}
+
+	elems := make([]int, len(pinned)+len(others))
+	for n, i := range pinned {
+		if n < 1 || n > len(elems) {
+			return nil, fmt.Errorf("%s: field %s is tagged bert:\"%d\", out of the %d tuple elements",
+				t, t.Field(i).Name, n, len(elems))
+		}
+		elems[n-1] = i
+	}
+	for pos := range elems {
+		if _, ok := pinned[pos+1]; !ok {
+			elems[pos], others = others[0], others[1:]
+		}
+	}
+	return elems, nil
+}
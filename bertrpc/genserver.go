@@ -0,0 +1,59 @@
+package bertrpc
+
+import (
+	"fmt"
+)
+
+// ============================================================================
+// gen_server protocol
+
+// GenCall is the message gen_server:call/2 sends to a process: {'$gen_call', {From, Tag}, Request}.
+// A process emulating a gen_server in Go decodes it to learn who to answer, and with which tag.
+// Tag is a Reference, or the [alias | Reference] ImproperList sent since OTP 24; it is kept as
+// decoded so that Reply can return it unchanged. Request is decoded like when decoding into an
+// interface{}.
+type GenCall struct {
+	From    Pid
+	Tag     interface{}
+	Request interface{}
+}
+
+// FromTerm decodes a $gen_call tuple.
+func (c *GenCall) FromTerm(term interface{}) error {
+	t, ok := term.(Tuple)
+	if !ok || len(t.Elems) != 3 || t.Elems[0] != (Atom{Value: "$gen_call"}) {
+		return fmt.Errorf("cannot decode %v as a $gen_call tuple", term)
+	}
+	from, ok := t.Elems[1].(Tuple)
+	if !ok || len(from.Elems) != 2 {
+		return fmt.Errorf("cannot decode %v as the {Pid, Tag} of a $gen_call", t.Elems[1])
+	}
+	pid, ok := from.Elems[0].(Pid)
+	if !ok {
+		return fmt.Errorf("cannot decode %v as the pid of a $gen_call", from.Elems[0])
+	}
+	if !isGenCallTag(from.Elems[1]) {
+		return fmt.Errorf("cannot decode %v as the tag of a $gen_call", from.Elems[1])
+	}
+
+	*c = GenCall{From: pid, Tag: from.Elems[1], Request: t.Elems[2]}
+	return nil
+}
+
+// isGenCallTag reports whether tag is a Reference, or an [alias | Reference] improper list.
+func isGenCallTag(tag interface{}) bool {
+	if alias, ok := tag.(ImproperList); ok {
+		if len(alias.Elems) != 1 || alias.Elems[0] != (Atom{Value: "alias"}) {
+			return false
+		}
+		tag = alias.Tail
+	}
+	_, ok := tag.(Reference)
+	return ok
+}
+
+// Reply returns the message to send to c.From to answer the call, as gen_server:reply/2 does: {Tag, Reply}.
+// The caller matches it with the tag of its call, so it must be sent to c.From unchanged.
+func (c GenCall) Reply(reply interface{}) Tuple {
+	return T(c.Tag, reply)
+}
@@ -0,0 +1,90 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestDecodeGenCall(t *testing.T) {
+	// {'$gen_call', {<0.85.0>, #Ref<0.3.2.1>}, {get, key}}
+	input := []byte{131, 104, 3,
+		119, 9, 36, 103, 101, 110, 95, 99, 97, 108, 108,
+		104, 2,
+		88, 119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116, 0, 0, 0, 85, 0, 0, 0, 0, 0, 0, 0, 0,
+		90, 0, 3, 119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3,
+		104, 2, 119, 3, 103, 101, 116, 119, 3, 107, 101, 121}
+
+	var call bertrpc.GenCall
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &call); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := bertrpc.GenCall{
+		From:    bertrpc.Pid{Node: "nonode@nohost", ID: 85},
+		Tag:     bertrpc.Reference{Node: "nonode@nohost", ID: []uint32{1, 2, 3}},
+		Request: bertrpc.T(bertrpc.Atom{Value: "get"}, bertrpc.Atom{Value: "key"}),
+	}
+	if !reflect.DeepEqual(call, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", call, want)
+	}
+	if s := call.Tag.(bertrpc.Reference).String(); s != "#Ref<nonode@nohost.3.2.1>" {
+		t.Errorf("incorrect reference string: %s", s)
+	}
+
+	// {#Ref<0.3.2.1>, {ok, 42}}
+	data, err := bertrpc.Encode(call.Reply(bertrpc.T(bertrpc.A("ok"), 42)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []byte{131, 104, 2,
+		90, 0, 3, 119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3,
+		104, 2, 119, 2, 111, 107, 97, 42}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("GenCall.Reply: expected %v, actual %v", expected, data)
+	}
+
+	// {'$gen_call', {<0.85.0>, [alias | #Ref<0.3.2.1>]}, ping}, as sent since OTP 24
+	input = []byte{131, 104, 3,
+		119, 9, 36, 103, 101, 110, 95, 99, 97, 108, 108,
+		104, 2,
+		88, 119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116, 0, 0, 0, 85, 0, 0, 0, 0, 0, 0, 0, 0,
+		108, 0, 0, 0, 1, 119, 5, 97, 108, 105, 97, 115,
+		90, 0, 3, 119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3,
+		119, 4, 112, 105, 110, 103}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &call); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	tag := bertrpc.ImproperList{
+		Elems: []interface{}{bertrpc.Atom{Value: "alias"}},
+		Tail:  bertrpc.Reference{Node: "nonode@nohost", ID: []uint32{1, 2, 3}},
+	}
+	want = bertrpc.GenCall{From: bertrpc.Pid{Node: "nonode@nohost", ID: 85}, Tag: tag, Request: bertrpc.Atom{Value: "ping"}}
+	if !reflect.DeepEqual(call, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", call, want)
+	}
+
+	// {[alias | #Ref<0.3.2.1>], pong}
+	data, err = bertrpc.Encode(call.Reply(bertrpc.A("pong")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected = []byte{131, 104, 2,
+		108, 0, 0, 0, 1, 119, 5, 97, 108, 105, 97, 115,
+		90, 0, 3, 119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3,
+		119, 4, 112, 111, 110, 103}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("GenCall.Reply: expected %v, actual %v", expected, data)
+	}
+
+	// {'$gen_cast', hello}
+	input = []byte{131, 104, 2, 119, 9, 36, 103, 101, 110, 95, 99, 97, 115, 116, 119, 5, 104, 101, 108, 108, 111}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &call); err == nil {
+		t.Errorf("decoding a $gen_cast as a $gen_call should fail")
+	}
+}
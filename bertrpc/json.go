@@ -0,0 +1,278 @@
+package bertrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+)
+
+// ============================================================================
+// JSON to Erlang terms
+
+// A json.RawMessage is not encoded as a binary holding the JSON text, but as the Erlang term
+// equivalent to the JSON value:
+// - objects are encoded as maps, with binary keys,
+// - arrays are encoded as lists,
+// - strings are encoded as binaries,
+// - numbers are encoded as integers when they have no fraction nor exponent, and as floats otherwise,
+// - true and false are encoded as the atoms true and false,
+// - null is encoded as the atom undefined.
+// It lets a service accept JSON and forward it as Erlang terms, without knowing its structure.
+func encodeJSON(buf termWriter, data json.RawMessage) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var value interface{}
+	if err := d.Decode(&value); err != nil {
+		return fmt.Errorf("cannot transcode JSON: %s", err)
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return fmt.Errorf("cannot transcode JSON: data left after the JSON value")
+	}
+
+	term, err := jsonTerm(value)
+	if err != nil {
+		return err
+	}
+	return encodePayloadTo(term, buf)
+}
+
+// jsonTerm returns the term to encode for a value decoded by encoding/json.
+func jsonTerm(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return A("undefined"), nil
+	case json.Number:
+		return jsonNumber(v)
+	case []interface{}:
+		for i, elem := range v {
+			term, err := jsonTerm(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = term
+		}
+		return v, nil
+	case map[string]interface{}:
+		for key, elem := range v {
+			term, err := jsonTerm(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = term
+		}
+		return v, nil
+	default:
+		// string and bool
+		return v, nil
+	}
+}
+
+func jsonNumber(n json.Number) (interface{}, error) {
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	// Integers too large for an int64 are kept exact, as Erlang integers have no size limit.
+	if i, ok := new(big.Int).SetString(string(n), 10); ok {
+		return i, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("cannot transcode JSON number %s: %s", n, err)
+	}
+	return f, nil
+}
+
+// ============================================================================
+// Erlang terms to JSON
+
+// TranscodeToJSON decodes an Erlang term, version tag included, and returns the equivalent JSON value.
+// The JSON is written while the term is read, without building a Go value first:
+// - integers are written as JSON numbers, whatever their size,
+// - floats are written as JSON numbers,
+// - the atoms true and false are written as booleans, and the atom undefined as null,
+// - other atoms, binaries and strings are written as JSON strings,
+// - lists and tuples are written as arrays,
+// - maps are written as objects, whose keys must be atoms, binaries, strings or integers.
+// The mapping is lossy: an atom and a binary with the same text give the same JSON string, a tuple and
+// a list with the same elements give the same array, invalid UTF-8 in binaries is replaced by U+FFFD,
+// and integers outside the float64 precision may be rounded by JSON parsers.
+// Pids and the other Erlang types without a JSON equivalent return an error.
+func TranscodeToJSON(r io.Reader) ([]byte, error) {
+	r, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := transcodeJSON(r, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func transcodeJSON(r io.Reader, buf *bytes.Buffer) error {
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return err
+	}
+
+	switch tag := int(byte1[0]); tag {
+	case TagSmallInteger, TagInteger:
+		i, err := decodeIntBody(r, tag)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(strconv.FormatInt(i, 10))
+
+	case TagBigInteger, TagLargeBigInteger:
+		i, err := decodeBigIntBody(r, tag)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(i.String())
+
+	case TagNewFloat, TagFloat:
+		f, err := decodeFloatBody(r, tag)
+		if err != nil {
+			return err
+		}
+		return writeJSON(buf, f)
+
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		atom, err := readAtomBody(r, tag)
+		if err != nil {
+			return err
+		}
+		switch atom {
+		case "true", "false":
+			buf.WriteString(atom)
+		case "undefined":
+			buf.WriteString("null")
+		default:
+			return writeJSON(buf, atom)
+		}
+
+	case TagString:
+		data, err := decodeString2(r)
+		if err != nil {
+			return err
+		}
+		return writeJSON(buf, latin1(data))
+
+	case TagBinary:
+		data, err := decodeString4(r)
+		if err != nil {
+			return err
+		}
+		return writeJSON(buf, string(data))
+
+	case TagNil:
+		buf.WriteString("[]")
+
+	case TagList:
+		byte4 := make([]byte, 4)
+		if err := readFull(r, byte4); err != nil {
+			return err
+		}
+		if err := transcodeJSONArray(r, buf, int(binary.BigEndian.Uint32(byte4))); err != nil {
+			return err
+		}
+		// Improper lists have no JSON equivalent
+		return decodeNil(r)
+
+	case TagSmallTuple, TagLargeTuple:
+		arity, err := readTupleArity(r, tag)
+		if err != nil {
+			return err
+		}
+		return transcodeJSONArray(r, buf, arity)
+
+	case TagMap:
+		arity, err := readMapArity(r)
+		if err != nil {
+			return err
+		}
+		return transcodeJSONObject(r, buf, arity)
+
+	default:
+		return fmt.Errorf("cannot transcode %s to JSON", TagName(tag))
+	}
+	return nil
+}
+
+func transcodeJSONArray(r io.Reader, buf *bytes.Buffer, length int) error {
+	buf.WriteByte('[')
+	for i := 0; i < length; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := transcodeJSON(r, buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+func transcodeJSONObject(r io.Reader, buf *bytes.Buffer, arity int) error {
+	buf.WriteByte('{')
+	for i := 0; i < arity; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := readJSONKey(r)
+		if err != nil {
+			return err
+		}
+		if err := writeJSON(buf, key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := transcodeJSON(r, buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// readJSONKey reads a map key, that must be written as a string in JSON.
+func readJSONKey(r io.Reader) (string, error) {
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return "", err
+	}
+
+	switch tag := int(byte1[0]); tag {
+	case TagSmallInteger, TagInteger, TagBigInteger, TagLargeBigInteger:
+		i, err := decodeBigIntBody(r, tag)
+		if err != nil {
+			return "", err
+		}
+		return i.String(), nil
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		return readAtomBody(r, tag)
+	case TagString:
+		data, err := decodeString2(r)
+		return latin1(data), err
+	case TagBinary:
+		data, err := decodeString4(r)
+		return string(data), err
+	case TagNil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("cannot use %s as a JSON object key", TagName(tag))
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
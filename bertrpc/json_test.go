@@ -0,0 +1,104 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	data, err := bertrpc.Encode(json.RawMessage(`{"ok": true, "id": 1}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// #{<<"id">> => 1, <<"ok">> => true}
+	expected := []byte{131, 116, 0, 0, 0, 2,
+		109, 0, 0, 0, 2, 105, 100, 97, 1,
+		109, 0, 0, 0, 2, 111, 107, 119, 4, 116, 114, 117, 101}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeJSON: expected %v, actual %v", expected, data)
+	}
+
+	input := json.RawMessage(`{"user": {"name": "jo", "tags": ["a", 2.5, null]}}`)
+	data, err = bertrpc.Encode(input)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var decoded interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		t.Errorf("incorrect decoded value: %#v", decoded)
+		return
+	}
+	user := map[interface{}]interface{}{
+		"name": "jo",
+		"tags": []interface{}{"a", 2.5, bertrpc.Atom{Value: "undefined"}},
+	}
+	if !reflect.DeepEqual(m["user"], user) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", m["user"], user)
+	}
+
+	// Integers do not lose precision, whatever their size.
+	data, err = bertrpc.Encode(json.RawMessage(`18446744073709551616`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected, _ := bertrpc.Encode(new(big.Int).Lsh(big.NewInt(1), 64)); !bytes.Equal(data, expected) {
+		t.Errorf("EncodeJSON: expected %v, actual %v", expected, data)
+	}
+
+	for _, invalid := range []string{`{"a": }`, `[1] [2]`} {
+		if _, err := bertrpc.Encode(json.RawMessage(invalid)); err == nil {
+			t.Errorf("transcoding %s should fail", invalid)
+		}
+	}
+}
+
+func TestTranscodeToJSON(t *testing.T) {
+	term := map[interface{}]interface{}{
+		bertrpc.A("name"): "jo",
+		bertrpc.A("tags"): bertrpc.L(bertrpc.A("admin"), bertrpc.CharList{Value: "dev"}),
+		bertrpc.A("pos"):  bertrpc.T(1.5, -2),
+		bertrpc.A("big"):  new(big.Int).Lsh(big.NewInt(1), 64),
+		bertrpc.A("ok"):   true,
+		bertrpc.A("none"): bertrpc.A("undefined"),
+		42:                bertrpc.L(),
+	}
+	data, err := bertrpc.Encode(term)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	js, err := bertrpc.TranscodeToJSON(bytes.NewBuffer(data))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// Keys are written in the order of the Erlang map: integers come before atoms.
+	expected := `{"42":[],"big":18446744073709551616,"name":"jo","none":null,"ok":true,` +
+		`"pos":[1.5,-2],"tags":["admin","dev"]}`
+	if string(js) != expected {
+		t.Errorf("TranscodeToJSON: expected %s, actual %s", expected, js)
+	}
+	if !json.Valid(js) {
+		t.Errorf("TranscodeToJSON returned invalid JSON: %s", js)
+	}
+
+	// {self(), 1}
+	pid, _ := bertrpc.Encode(bertrpc.T(bertrpc.Pid{Node: "a@b", ID: 1}, 1))
+	if _, err := bertrpc.TranscodeToJSON(bytes.NewBuffer(pid)); err == nil {
+		t.Errorf("transcoding a pid to JSON should fail")
+	}
+}
@@ -0,0 +1,127 @@
+package bertrpc
+
+import (
+	"fmt"
+	"io"
+)
+
+// ModuleInfo holds the description of an Erlang module, as returned by Module:module_info().
+type ModuleInfo struct {
+	Module     string
+	Exports    []Function
+	Attributes []Property
+	Compile    []Property
+	MD5        []byte
+}
+
+// Function identifies an Erlang function by its name and arity.
+type Function struct {
+	Name  string
+	Arity int
+}
+
+// Property is an element of an Erlang proplist.
+// A bare atom in a proplist is a shorthand for {Atom, true}.
+type Property struct {
+	Key   string
+	Value interface{}
+}
+
+// DecodeModuleInfo decodes the result of a call to Module:module_info().
+// Unknown entries are ignored.
+func DecodeModuleInfo(r io.Reader) (ModuleInfo, error) {
+	r, err := readHeader(r)
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+	term, err := decodeDynamic(r)
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+
+	var info ModuleInfo
+	props, err := proplist(term)
+	if err != nil {
+		return info, err
+	}
+	for _, prop := range props {
+		switch prop.Key {
+		case "module":
+			module, ok := prop.Value.(Atom)
+			if !ok {
+				return info, fmt.Errorf("module name should be an atom: %v", prop.Value)
+			}
+			info.Module = module.Value
+		case "exports":
+			if info.Exports, err = functions(prop.Value); err != nil {
+				return info, err
+			}
+		case "attributes":
+			if info.Attributes, err = proplist(prop.Value); err != nil {
+				return info, err
+			}
+		case "compile":
+			if info.Compile, err = proplist(prop.Value); err != nil {
+				return info, err
+			}
+		case "md5":
+			md5, ok := prop.Value.(string)
+			if !ok {
+				return info, fmt.Errorf("md5 should be a binary: %v", prop.Value)
+			}
+			info.MD5 = []byte(md5)
+		}
+	}
+	return info, nil
+}
+
+// proplist converts a dynamically decoded proplist into a list of properties.
+func proplist(term interface{}) ([]Property, error) {
+	list, ok := term.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("proplist should be a list: %v", term)
+	}
+
+	props := make([]Property, len(list))
+	for i, elem := range list {
+		switch e := elem.(type) {
+		case Atom:
+			props[i] = Property{Key: e.Value, Value: true}
+		case Tuple:
+			if len(e.Elems) != 2 {
+				return nil, fmt.Errorf("proplist element should be a {Key, Value} tuple: %v", e)
+			}
+			key, ok := e.Elems[0].(Atom)
+			if !ok {
+				return nil, fmt.Errorf("proplist key should be an atom: %v", e.Elems[0])
+			}
+			props[i] = Property{Key: key.Value, Value: e.Elems[1]}
+		default:
+			return nil, fmt.Errorf("unexpected proplist element: %v", elem)
+		}
+	}
+	return props, nil
+}
+
+// functions converts a dynamically decoded list of {Name, Arity} tuples.
+func functions(term interface{}) ([]Function, error) {
+	list, ok := term.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("function list should be a list: %v", term)
+	}
+
+	fns := make([]Function, len(list))
+	for i, elem := range list {
+		fn, ok := elem.(Tuple)
+		if !ok || len(fn.Elems) != 2 {
+			return nil, fmt.Errorf("function should be a {Name, Arity} tuple: %v", elem)
+		}
+		name, ok1 := fn.Elems[0].(Atom)
+		arity, ok2 := fn.Elems[1].(int64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("function should be a {Name, Arity} tuple: %v", elem)
+		}
+		fns[i] = Function{Name: name.Value, Arity: int(arity)}
+	}
+	return fns, nil
+}
@@ -0,0 +1,50 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestDecodeModuleInfo(t *testing.T) {
+	md5 := string([]byte{16, 95, 128, 224, 184, 42, 3, 24, 108, 161, 112, 253, 221, 153, 61, 26})
+	term := bertrpc.L(
+		bertrpc.T(bertrpc.A("module"), bertrpc.A("hello")),
+		bertrpc.T(bertrpc.A("exports"), bertrpc.L(
+			bertrpc.T(bertrpc.A("world"), 1),
+			bertrpc.T(bertrpc.A("module_info"), 0))),
+		bertrpc.T(bertrpc.A("attributes"), bertrpc.L(
+			bertrpc.T(bertrpc.A("vsn"), bertrpc.L(300)))),
+		bertrpc.T(bertrpc.A("compile"), bertrpc.L(
+			bertrpc.T(bertrpc.A("version"), "8.0.2"),
+			bertrpc.T(bertrpc.A("source"), "/tmp/hello.erl"))),
+		bertrpc.T(bertrpc.A("md5"), md5),
+	)
+	data, err := bertrpc.Encode(term)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	info, err := bertrpc.DecodeModuleInfo(bytes.NewBuffer(data))
+	if err != nil {
+		t.Errorf("cannot decode module info: %s", err)
+		return
+	}
+
+	want := bertrpc.ModuleInfo{
+		Module:     "hello",
+		Exports:    []bertrpc.Function{{Name: "world", Arity: 1}, {Name: "module_info", Arity: 0}},
+		Attributes: []bertrpc.Property{{Key: "vsn", Value: []interface{}{int64(300)}}},
+		Compile: []bertrpc.Property{
+			{Key: "version", Value: "8.0.2"},
+			{Key: "source", Value: "/tmp/hello.erl"},
+		},
+		MD5: []byte(md5),
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("incorrect module info: %#v (!= %#v)", info, want)
+	}
+}
@@ -0,0 +1,232 @@
+package bertrpc
+
+import (
+	"math/big"
+	"strings"
+)
+
+// ============================================================================
+// Erlang term order
+
+// Erlang defines a total order between terms of different types:
+// number < atom < reference < fun < port < pid < tuple < map < nil < list < bit string
+// Nil is the empty list, so it is handled with lists, as it is always smaller than any other list.
+const (
+	orderNumber = iota
+	orderAtom
+	orderReference
+	orderPort
+	orderPid
+	orderTuple
+	orderMap
+	orderList
+	orderBinary
+	orderUnknown
+)
+
+func termOrderClass(term interface{}) int {
+	switch t := term.(type) {
+	case int64, *big.Int, float64:
+		return orderNumber
+	case Atom:
+		return orderAtom
+	case String:
+		if t.IsAtom() {
+			return orderAtom
+		}
+		return orderBinary
+	case string:
+		return orderBinary
+	case Reference:
+		return orderReference
+	case Port:
+		return orderPort
+	case Pid:
+		return orderPid
+	case Tuple:
+		return orderTuple
+	case map[interface{}]interface{}:
+		return orderMap
+	case []interface{}, ImproperList:
+		return orderList
+	default:
+		return orderUnknown
+	}
+}
+
+// compareTerms compares two decoded terms using Erlang term order.
+// It returns a negative number when a < b, zero when a == b and a positive number when a > b.
+func compareTerms(a, b interface{}) int {
+	classA, classB := termOrderClass(a), termOrderClass(b)
+	if classA != classB {
+		return classA - classB
+	}
+
+	switch classA {
+	case orderNumber:
+		return compareNumbers(a, b)
+	case orderAtom, orderBinary:
+		return strings.Compare(termText(a), termText(b))
+	case orderReference:
+		x, y := a.(Reference), b.(Reference)
+		if x.Node != y.Node {
+			return strings.Compare(x.Node, y.Node)
+		}
+		// The most significant words come last
+		return compareUint32s(reversed(x.ID), reversed(y.ID))
+	case orderPort:
+		x, y := a.(Port), b.(Port)
+		if x.Node != y.Node {
+			return strings.Compare(x.Node, y.Node)
+		}
+		return compareUint32s([]uint32{x.ID, x.Creation}, []uint32{y.ID, y.Creation})
+	case orderPid:
+		x, y := a.(Pid), b.(Pid)
+		if x.Node != y.Node {
+			return strings.Compare(x.Node, y.Node)
+		}
+		return compareUint32s([]uint32{x.ID, x.Serial, x.Creation}, []uint32{y.ID, y.Serial, y.Creation})
+	case orderTuple:
+		// Tuples are ordered by size first, then element by element.
+		x, y := a.(Tuple).Elems, b.(Tuple).Elems
+		if len(x) != len(y) {
+			return len(x) - len(y)
+		}
+		return compareElems(x, y)
+	case orderList:
+		return compareLists(a, b)
+	case orderMap:
+		// Only the size of maps is compared.
+		return len(a.(map[interface{}]interface{})) - len(b.(map[interface{}]interface{}))
+	default:
+		return 0
+	}
+}
+
+// Integers and floats compare by value. When they are equal, the integer comes first.
+func compareNumbers(a, b interface{}) int {
+	x, xInt := toBigInt(a)
+	y, yInt := toBigInt(b)
+	if xInt && yInt {
+		return x.Cmp(y)
+	}
+
+	fx, fy := toFloat(a), toFloat(b)
+	switch {
+	case fx < fy:
+		return -1
+	case fx > fy:
+		return 1
+	case xInt && !yInt:
+		return -1
+	case !xInt && yInt:
+		return 1
+	}
+	return 0
+}
+
+// toBigInt returns an integer, decoded as an int64 or a *big.Int, as a *big.Int.
+func toBigInt(number interface{}) (*big.Int, bool) {
+	switch n := number.(type) {
+	case int64:
+		return big.NewInt(n), true
+	case *big.Int:
+		return n, true
+	}
+	return nil, false
+}
+
+func toFloat(number interface{}) float64 {
+	switch n := number.(type) {
+	case int64:
+		return float64(n)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f
+	}
+	return number.(float64)
+}
+
+func compareUint32s(x, y []uint32) int {
+	for i := 0; i < len(x) && i < len(y); i++ {
+		switch {
+		case x[i] < y[i]:
+			return -1
+		case x[i] > y[i]:
+			return 1
+		}
+	}
+	return len(x) - len(y)
+}
+
+func reversed(words []uint32) []uint32 {
+	r := make([]uint32, len(words))
+	for i, w := range words {
+		r[len(words)-1-i] = w
+	}
+	return r
+}
+
+// Lists are compared element by element, a shorter list being smaller than a longer one.
+// Once the elements of a list are exhausted, its tail is compared to the rest of the other list.
+func compareLists(a, b interface{}) int {
+	x, xTail := listParts(a)
+	y, yTail := listParts(b)
+	if xTail == nil && yTail == nil {
+		if c := compareElems(x, y); c != 0 {
+			return c
+		}
+		return len(x) - len(y)
+	}
+
+	n := len(x)
+	if len(y) < n {
+		n = len(y)
+	}
+	if c := compareElems(x[:n], y[:n]); c != 0 {
+		return c
+	}
+	return compareTerms(listRest(x[n:], xTail), listRest(y[n:], yTail))
+}
+
+func listParts(list interface{}) ([]interface{}, interface{}) {
+	if l, ok := list.(ImproperList); ok {
+		return l.Elems, l.Tail
+	}
+	return list.([]interface{}), nil
+}
+
+// listRest returns the list made of elems followed by tail.
+func listRest(elems []interface{}, tail interface{}) interface{} {
+	switch {
+	case len(elems) > 0 && tail != nil:
+		return ImproperList{Elems: elems, Tail: tail}
+	case len(elems) > 0 || tail == nil:
+		return elems
+	default:
+		return tail
+	}
+}
+
+// compareElems compares the common prefix of two lists of terms.
+func compareElems(x, y []interface{}) int {
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if c := compareTerms(x[i], y[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func termText(term interface{}) string {
+	switch t := term.(type) {
+	case Atom:
+		return t.Value
+	case String:
+		return t.Value
+	case string:
+		return t
+	default:
+		return ""
+	}
+}
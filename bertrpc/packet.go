@@ -0,0 +1,182 @@
+package bertrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ============================================================================
+// BERP framing: each packet is an Erlang term prefixed by its length, on 4 bytes.
+
+// ErrTruncatedFrame is returned when a stream ends in the middle of a frame.
+var ErrTruncatedFrame = errors.New("truncated frame")
+
+// WritePacket writes term to w as a BERP packet: its encoding, prefixed by its length.
+// The packet is written with a single call to Write.
+func WritePacket(w io.Writer, term interface{}) error {
+	var buf bytes.Buffer
+	if err := encodePacket(&buf, term); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadPacket reads a BERP packet from r and decodes its term into term. The whole packet is read
+// before being decoded, so that r is positioned on the next packet even when the term cannot be decoded.
+// It returns io.EOF when r ends before a packet, and ErrTruncatedFrame when it ends in the middle of one.
+func ReadPacket(r io.Reader, term interface{}) error {
+	byte4 := make([]byte, 4)
+	if _, err := io.ReadFull(r, byte4); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return ErrTruncatedFrame
+		}
+		return err
+	}
+	data, err := readFrameData(r, binary.BigEndian.Uint32(byte4))
+	if err != nil {
+		return err
+	}
+	return Unmarshal(data, term)
+}
+
+// EncodeStream copies framed terms that are already encoded from r to w, without decoding them.
+// Each frame is checked to be complete and to contain an Erlang term before being written.
+// It returns nil when r ends on a frame boundary.
+// It is handy to relay Erlang terms from one connection to another.
+func EncodeStream(w io.Writer, r io.Reader) error {
+	for {
+		frame, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, uint32(len(frame)))
+		if _, err := w.Write(append(header, frame...)); err != nil {
+			return err
+		}
+	}
+}
+
+// DecodeFramesToChan reads framed terms from r until it ends, decodes each of them like when decoding
+// into an interface{}, and sends them on ch. It closes ch when it returns, on the end of r or on the first error.
+// It returns nil when r ends on a frame boundary.
+func DecodeFramesToChan(r io.Reader, ch chan<- interface{}) error {
+	defer close(ch)
+	for {
+		frame, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var term interface{}
+		if err := Unmarshal(frame, &term); err != nil {
+			return err
+		}
+		ch <- term
+	}
+}
+
+// FrameReader decodes framed terms from a stream. It keeps track of the bytes left in the current frame,
+// so that a stream can be resynchronized on the next frame after a term that cannot be decoded.
+type FrameReader struct {
+	r     io.Reader
+	frame io.LimitedReader
+}
+
+// NewFrameReader returns a FrameReader reading frames from r.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: r, frame: io.LimitedReader{R: r}}
+}
+
+// Decode reads the next frame and decodes its term into term. It returns io.EOF when the stream ends
+// on a frame boundary. When the term cannot be decoded, the rest of the frame is left unread:
+// Resync must be called before decoding the next frame.
+func (f *FrameReader) Decode(term interface{}) error {
+	if f.frame.N > 0 {
+		return fmt.Errorf("%d bytes left in the current frame", f.frame.N)
+	}
+
+	byte4 := make([]byte, 4)
+	if _, err := io.ReadFull(f.r, byte4); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return ErrTruncatedFrame
+		}
+		return err
+	}
+	f.frame.N = int64(binary.BigEndian.Uint32(byte4))
+
+	if err := Decode(&f.frame, term); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if f.frame.N == 0 {
+				return errors.New("Erlang term does not fit in its frame")
+			}
+			return ErrTruncatedFrame
+		}
+		return err
+	}
+	if f.frame.N > 0 {
+		return fmt.Errorf("%d trailing bytes after Erlang term", f.frame.N)
+	}
+	return nil
+}
+
+// Resync discards the rest of the current frame, so that the next call to Decode starts on the next frame.
+func (f *FrameReader) Resync() error {
+	n, err := io.CopyN(ioutil.Discard, f.r, f.frame.N)
+	f.frame.N -= n
+	if err == io.EOF {
+		return ErrTruncatedFrame
+	}
+	return err
+}
+
+// readFrame reads a complete frame and returns its content, without the length header.
+// It returns io.EOF if there is no more frame to read.
+func readFrame(r io.Reader) ([]byte, error) {
+	byte4 := make([]byte, 4)
+	if _, err := io.ReadFull(r, byte4); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrTruncatedFrame
+		}
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(byte4)
+	if length == 0 {
+		return nil, errors.New("empty frame")
+	}
+
+	frame, err := readFrameData(r, length)
+	if err != nil {
+		return nil, err
+	}
+	if frame[0] != TagETFVersion {
+		return nil, fmt.Errorf("incorrect Erlang Term version tag: %d", frame[0])
+	}
+	return frame, nil
+}
+
+// readFrameData reads the length bytes of a frame whose header has already been read.
+// The buffer grows with the data actually read, rather than with the announced length, so that a corrupt
+// header cannot allocate up to 4 GiB.
+func readFrameData(r io.Reader, length uint32) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < int64(length) {
+		return nil, ErrTruncatedFrame
+	}
+	return data, nil
+}
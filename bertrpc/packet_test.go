@@ -0,0 +1,151 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestEncodeStream(t *testing.T) {
+	// ok, then {ok, 42}. The source only returns one byte per read, so frames are split across reads.
+	input := []byte{0, 0, 0, 5, 131, 119, 2, 111, 107,
+		0, 0, 0, 9, 131, 104, 2, 119, 2, 111, 107, 97, 42}
+
+	var out bytes.Buffer
+	if err := bertrpc.EncodeStream(&out, iotest.OneByteReader(bytes.NewReader(input))); err != nil {
+		t.Errorf("cannot copy stream: %s", err)
+		return
+	}
+
+	if !bytes.Equal(out.Bytes(), input) {
+		t.Errorf("EncodeStream: expected %v, actual %v", input, out.Bytes())
+	}
+}
+
+func TestEncodeStreamTruncated(t *testing.T) {
+	input := []byte{0, 0, 0, 5, 131, 119, 2, 111, 107,
+		0, 0, 0, 9, 131, 104, 2, 119}
+
+	var out bytes.Buffer
+	if err := bertrpc.EncodeStream(&out, bytes.NewReader(input)); err != bertrpc.ErrTruncatedFrame {
+		t.Errorf("copying a truncated frame should fail: %v", err)
+	}
+
+	// The announced length is not trusted: the frame is reported as truncated without allocating 4 GiB.
+	input = []byte{255, 255, 255, 255, 131, 119, 2, 111, 107}
+	if err := bertrpc.EncodeStream(&out, bytes.NewReader(input)); err != bertrpc.ErrTruncatedFrame {
+		t.Errorf("copying a frame with a corrupt length should fail: %v", err)
+	}
+}
+
+func TestDecodeFramesToChan(t *testing.T) {
+	// ok, then {ok, 42}
+	input := []byte{0, 0, 0, 5, 131, 119, 2, 111, 107,
+		0, 0, 0, 9, 131, 104, 2, 119, 2, 111, 107, 97, 42}
+
+	ch := make(chan interface{})
+	errc := make(chan error, 1)
+	go func() { errc <- bertrpc.DecodeFramesToChan(bytes.NewReader(input), ch) }()
+
+	var terms []interface{}
+	for term := range ch {
+		terms = append(terms, term)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("cannot decode frames: %s", err)
+	}
+
+	ok := bertrpc.Atom{Value: "ok"}
+	want := []interface{}{ok, bertrpc.T(ok, int64(42))}
+	if !reflect.DeepEqual(terms, want) {
+		t.Errorf("incorrect decoded terms: %#v (!= %#v)", terms, want)
+	}
+}
+
+// The channel is closed on errors too.
+func TestDecodeFramesToChanTruncated(t *testing.T) {
+	input := []byte{0, 0, 0, 5, 131, 119, 2, 111, 107,
+		0, 0, 0, 9, 131, 104, 2, 119}
+
+	ch := make(chan interface{}, 2)
+	if err := bertrpc.DecodeFramesToChan(bytes.NewReader(input), ch); err != bertrpc.ErrTruncatedFrame {
+		t.Errorf("decoding a truncated frame should fail: %v", err)
+	}
+	if term := <-ch; term != (bertrpc.Atom{Value: "ok"}) {
+		t.Errorf("incorrect decoded term: %#v", term)
+	}
+	if _, open := <-ch; open {
+		t.Errorf("channel should be closed")
+	}
+}
+
+// After a corrupt frame, Resync skips to the next one.
+func TestFrameReaderResync(t *testing.T) {
+	// {ok, <corrupt>}, then {ok, 42}
+	input := []byte{0, 0, 0, 9, 131, 104, 2, 119, 2, 111, 107, 255, 42,
+		0, 0, 0, 9, 131, 104, 2, 119, 2, 111, 107, 97, 42}
+	f := bertrpc.NewFrameReader(iotest.OneByteReader(bytes.NewReader(input)))
+
+	var term interface{}
+	if err := f.Decode(&term); err == nil {
+		t.Errorf("decoding a corrupt frame should fail")
+	}
+	if err := f.Decode(&term); err == nil {
+		t.Errorf("decoding before resync should fail")
+	}
+	if err := f.Resync(); err != nil {
+		t.Errorf("cannot resync: %s", err)
+	}
+
+	if err := f.Decode(&term); err != nil {
+		t.Errorf("cannot decode frame: %s", err)
+		return
+	}
+	want := bertrpc.T(bertrpc.Atom{Value: "ok"}, int64(42))
+	if !reflect.DeepEqual(term, want) {
+		t.Errorf("incorrect decoded term: %#v (!= %#v)", term, want)
+	}
+	if err := f.Decode(&term); err != io.EOF {
+		t.Errorf("decoding at the end of the stream should return io.EOF: %v", err)
+	}
+}
+
+func TestWriteReadPacket(t *testing.T) {
+	var buf bytes.Buffer
+	if err := bertrpc.WritePacket(&buf, bertrpc.T(bertrpc.A("ok"), 42)); err != nil {
+		t.Errorf("cannot write packet: %s", err)
+		return
+	}
+	expected := []byte{0, 0, 0, 9, 131, 104, 2, 119, 2, 111, 107, 97, 42}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("WritePacket: expected %v, actual %v", expected, buf.Bytes())
+	}
+
+	// A packet that cannot be decoded into the target is still consumed entirely.
+	buf.Write([]byte{0, 0, 0, 5, 131, 119, 2, 111, 107})
+	var text string
+	if err := bertrpc.ReadPacket(&buf, &text); err == nil {
+		t.Errorf("decoding a tuple into a string should fail")
+	}
+	var atom bertrpc.Atom
+	if err := bertrpc.ReadPacket(&buf, &atom); err != nil {
+		t.Errorf("cannot read packet: %s", err)
+	} else if atom.Value != "ok" {
+		t.Errorf("ReadPacket: expected ok, actual %v", atom)
+	}
+	if err := bertrpc.ReadPacket(&buf, &atom); err != io.EOF {
+		t.Errorf("ReadPacket at the end of the stream: expected io.EOF, actual %v", err)
+	}
+}
+
+func TestReadPacketTruncated(t *testing.T) {
+	input := []byte{0, 0, 0, 9, 131, 104, 2, 119}
+	var term interface{}
+	if err := bertrpc.ReadPacket(bytes.NewReader(input), &term); err != bertrpc.ErrTruncatedFrame {
+		t.Errorf("reading a truncated packet: expected ErrTruncatedFrame, actual %v", err)
+	}
+}
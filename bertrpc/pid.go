@@ -0,0 +1,87 @@
+package bertrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Pid is an Erlang process identifier.
+type Pid struct {
+	Node     string
+	ID       uint32
+	Serial   uint32
+	Creation uint32
+}
+
+func (pid Pid) String() string {
+	return fmt.Sprintf("<%s.%d.%d>", pid.Node, pid.ID, pid.Serial)
+}
+
+// StartResult is the result of the functions starting a process, like gen_server:start_link/3 or
+// supervisor:start_child/2: {ok, Pid} or {error, Reason}.
+type StartResult struct {
+	Tag    string      `erlang:"tag"`
+	Pid    Pid         `erlang:"tag:ok"`
+	Reason interface{} `erlang:"tag:error"`
+}
+
+// Pids are always encoded as NEW_PID_EXT, with a 32 bits creation.
+func encodePid(buf termWriter, pid Pid) error {
+	buf.WriteByte(TagNewPid)
+	if err := encodeAtom(buf, pid.Node); err != nil {
+		return err
+	}
+	return binary.Write(buf, binary.BigEndian, []uint32{pid.ID, pid.Serial, pid.Creation})
+}
+
+func decodePid(r io.Reader, val reflect.Value) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return err
+	}
+
+	pid, err := decodePidBody(r, int(byte1[0]))
+	if err == nil {
+		val.Set(reflect.ValueOf(pid))
+	}
+	return err
+}
+
+// decodePidBody decodes a pid whose tag has already been read.
+// PID_EXT has a creation on 1 byte, while NEW_PID_EXT has it on 4 bytes.
+func decodePidBody(r io.Reader, tag int) (Pid, error) {
+	var creationSize int
+	switch tag {
+	case TagPid:
+		creationSize = 1
+	case TagNewPid:
+		creationSize = 4
+	default:
+		return Pid{}, TagError{Expected: TagNewPid, Got: tag}
+	}
+
+	node, err := readAtom(r)
+	if err != nil {
+		return Pid{}, err
+	}
+	data := make([]byte, 8+creationSize)
+	if err := readFull(r, data); err != nil {
+		return Pid{}, err
+	}
+
+	pid := Pid{
+		Node:   node,
+		ID:     binary.BigEndian.Uint32(data[0:4]),
+		Serial: binary.BigEndian.Uint32(data[4:8]),
+	}
+	if creationSize == 1 {
+		pid.Creation = uint32(data[8])
+	} else {
+		pid.Creation = binary.BigEndian.Uint32(data[8:12])
+	}
+	return pid, nil
+}
@@ -0,0 +1,92 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+// <0.80.0> on node nonode@nohost, as NEW_PID_EXT
+var pidBytes = []byte{88, 119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116,
+	0, 0, 0, 80, 0, 0, 0, 0, 0, 0, 0, 0}
+
+func TestDecodeStartResult(t *testing.T) {
+	pid := bertrpc.Pid{Node: "nonode@nohost", ID: 80}
+
+	// {ok, <0.80.0>}
+	input := append([]byte{131, 104, 2, 119, 2, 111, 107}, pidBytes...)
+	var res bertrpc.StartResult
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &res); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if res.Tag != "ok" || res.Pid != pid || res.Reason != nil {
+		t.Errorf("incorrect start result: %#v", res)
+	}
+
+	// The pid can be sent back to Erlang as is
+	data, err := bertrpc.Encode(res.Pid)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(data[1:], pidBytes) {
+		t.Errorf("incorrect encoded pid: %v (!= %v)", data[1:], pidBytes)
+	}
+
+	// {error, {already_started, <0.80.0>}}
+	input = append([]byte{131, 104, 2, 119, 5, 101, 114, 114, 111, 114, 104, 2,
+		119, 15, 97, 108, 114, 101, 97, 100, 121, 95, 115, 116, 97, 114, 116, 101, 100}, pidBytes...)
+	res = bertrpc.StartResult{}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &res); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	reason := bertrpc.T(bertrpc.Atom{Value: "already_started"}, pid)
+	if res.Tag != "error" || !reflect.DeepEqual(res.Reason, reason) {
+		t.Errorf("incorrect start result: %#v", res)
+	}
+}
+
+// PID_EXT, used before OTP 23, has a creation on a single byte.
+func TestDecodeLegacyPid(t *testing.T) {
+	input := []byte{131, 103, 100, 0, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116,
+		0, 0, 0, 80, 0, 0, 0, 1, 2}
+
+	var pid bertrpc.Pid
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &pid); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := bertrpc.Pid{Node: "nonode@nohost", ID: 80, Serial: 1, Creation: 2}
+	if pid != want {
+		t.Errorf("incorrect pid: %#v (!= %#v)", pid, want)
+	}
+}
+
+// Mixed clusters send both formats, possibly in the same term.
+func TestDecodeMixedPids(t *testing.T) {
+	legacy := []byte{103, 100, 0, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116,
+		0, 0, 0, 81, 0, 0, 0, 0, 3}
+	input := append(append(append([]byte{131, 108, 0, 0, 0, 2}, pidBytes...), legacy...), 106)
+	want := []bertrpc.Pid{{Node: "nonode@nohost", ID: 80}, {Node: "nonode@nohost", ID: 81, Creation: 3}}
+
+	var pids []bertrpc.Pid
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &pids); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(pids, want) {
+		t.Errorf("incorrect pids: %#v (!= %#v)", pids, want)
+	}
+
+	var term interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &term); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if expected := bertrpc.L(want[0], want[1]); !reflect.DeepEqual(term, expected) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", term, expected)
+	}
+}
@@ -0,0 +1,119 @@
+package bertrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned when getting a client from a closed Pool.
+var ErrPoolClosed = errors.New("bert-rpc pool closed")
+
+// Pool manages up to a fixed number of TCP connections to a BERT-RPC server, so that concurrent calls
+// do not wait for each other as they do on a single Client. Connections are opened when needed, and
+// kept open for the next calls. A Pool is safe for concurrent use.
+type Pool struct {
+	address string
+	// slots holds a token for each client in use or idle, so that there are never more than its capacity.
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*Client
+	closed bool
+}
+
+// NewPool returns a pool of at most size connections to the BERT-RPC server at address,
+// a host and port like "localhost:9999". A size below 1 is taken as 1.
+func NewPool(address string, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{address: address, slots: make(chan struct{}, size)}
+}
+
+// Get returns a client from the pool, connecting a new one if none is idle. When all the connections
+// are in use, it waits for one to be put back until ctx is done. The client must be given back with Put.
+func (p *Pool) Get(ctx context.Context) (*Client, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	c, err := dial(ctx, p.address)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// Put gives back a client returned by Get. err is the error of the last call made with the client:
+// unless the call succeeded or the server answered with an error, the connection may be out of sync
+// with the server, so it is closed instead of being reused.
+func (p *Pool) Put(c *Client, err error) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || !healthy(err) {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+// Call calls Module:Function(Args...) on the server with a client from the pool, like Client.Call.
+func (p *Pool) Call(module, function string, args ...interface{}) (interface{}, error) {
+	return p.CallContext(context.Background(), module, function, args...)
+}
+
+// CallContext is like Call, but gives up when ctx is done, including while waiting for a connection.
+func (p *Pool) CallContext(ctx context.Context, module, function string, args ...interface{}) (interface{}, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.CallContext(ctx, module, function, args...)
+	p.Put(c, err)
+	return result, err
+}
+
+// Close closes the idle connections of the pool. Connections in use are closed when they are put back,
+// and Get fails with ErrPoolClosed from now on.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var err error
+	for _, c := range p.idle {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	p.idle = nil
+	return err
+}
+
+// healthy tells if a connection can be reused after a call that returned err.
+func healthy(err error) bool {
+	switch err.(type) {
+	case nil, RPCError:
+		return true
+	}
+	return err == ErrCacheValid
+}
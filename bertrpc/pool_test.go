@@ -0,0 +1,147 @@
+package bertrpc_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return c, err
+}
+
+func startPoolServer(t *testing.T) (*bertrpc.Server, *countingListener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	counting := &countingListener{Listener: l}
+
+	server := new(bertrpc.Server)
+	server.Register("calc", map[string]func(args []interface{}) (interface{}, error){
+		"add": func(args []interface{}) (interface{}, error) {
+			return args[0].(int64) + args[1].(int64), nil
+		},
+		"sleep": func(args []interface{}) (interface{}, error) {
+			time.Sleep(200 * time.Millisecond)
+			return nil, nil
+		},
+	})
+	go server.Serve(counting)
+	return server, counting
+}
+
+// Concurrent calls share the connections of the pool, without opening more than its size.
+func TestPoolStress(t *testing.T) {
+	server, l := startPoolServer(t)
+	defer server.Close()
+
+	pool := bertrpc.NewPool(l.Addr().String(), 4)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				result, err := pool.Call("calc", "add", g, i)
+				if err != nil {
+					t.Errorf("call %d of goroutine %d: %s", i, g, err)
+					return
+				}
+				if result != int64(g+i) {
+					t.Errorf("incorrect result: %#v (!= %d)", result, g+i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&l.accepted); n > 4 {
+		t.Errorf("the pool opened %d connections, more than its size", n)
+	}
+}
+
+func TestPoolExhausted(t *testing.T) {
+	server, l := startPoolServer(t)
+	defer server.Close()
+
+	pool := bertrpc.NewPool(l.Addr().String(), 1)
+	defer pool.Close()
+
+	client, err := pool.Get(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The only connection is in use
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx); err != context.DeadlineExceeded {
+		t.Errorf("getting a client from an exhausted pool: expected context.DeadlineExceeded, actual %v", err)
+	}
+
+	// Errors returned by the server do not affect the connection, so it is reused
+	_, err = client.Call("calc", "mul", 1, 2)
+	if _, ok := err.(bertrpc.RPCError); !ok {
+		t.Errorf("expected an RPCError: %v", err)
+	}
+	pool.Put(client, err)
+	if result, err := pool.Call("calc", "add", 1, 2); err != nil || result != int64(3) {
+		t.Errorf("incorrect result: %#v (%v)", result, err)
+	}
+	if n := atomic.LoadInt32(&l.accepted); n != 1 {
+		t.Errorf("expected 1 connection, the pool opened %d", n)
+	}
+
+	// A call that times out leaves its connection out of sync: it is replaced
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.CallContext(ctx, "calc", "sleep"); err != context.DeadlineExceeded {
+		t.Errorf("calling without answer: expected context.DeadlineExceeded, actual %v", err)
+	}
+	if result, err := pool.Call("calc", "add", 1, 2); err != nil || result != int64(3) {
+		t.Errorf("incorrect result: %#v (%v)", result, err)
+	}
+	if n := atomic.LoadInt32(&l.accepted); n != 2 {
+		t.Errorf("expected 2 connections, the pool opened %d", n)
+	}
+
+	pool.Close()
+	if _, err := pool.Get(context.Background()); err != bertrpc.ErrPoolClosed {
+		t.Errorf("getting a client from a closed pool: expected ErrPoolClosed, actual %v", err)
+	}
+}
+
+// A pool always allows at least one connection.
+func TestPoolSize(t *testing.T) {
+	server, l := startPoolServer(t)
+	defer server.Close()
+
+	for _, size := range []int{0, -1} {
+		pool := bertrpc.NewPool(l.Addr().String(), size)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if result, err := pool.CallContext(ctx, "calc", "add", 1, 2); err != nil || result != int64(3) {
+			t.Errorf("incorrect result with a size of %d: %#v (%v)", size, result, err)
+		}
+		cancel()
+		pool.Close()
+	}
+}
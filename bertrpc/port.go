@@ -0,0 +1,74 @@
+package bertrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Port is an Erlang port identifier, as returned by open_port/2 or erlang:ports/0.
+type Port struct {
+	Node     string
+	ID       uint32
+	Creation uint32
+}
+
+func (port Port) String() string {
+	return fmt.Sprintf("#Port<%s.%d>", port.Node, port.ID)
+}
+
+// Ports are always encoded as NEW_PORT_EXT, with a 32 bits creation.
+func encodePort(buf termWriter, port Port) error {
+	buf.WriteByte(TagNewPort)
+	if err := encodeAtom(buf, port.Node); err != nil {
+		return err
+	}
+	return binary.Write(buf, binary.BigEndian, []uint32{port.ID, port.Creation})
+}
+
+func decodePort(r io.Reader, val reflect.Value) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return err
+	}
+
+	port, err := decodePortBody(r, int(byte1[0]))
+	if err == nil {
+		val.Set(reflect.ValueOf(port))
+	}
+	return err
+}
+
+// decodePortBody decodes a port whose tag has already been read.
+// PORT_EXT has a creation on 1 byte, while NEW_PORT_EXT has it on 4 bytes.
+func decodePortBody(r io.Reader, tag int) (Port, error) {
+	var creationSize int
+	switch tag {
+	case TagPort:
+		creationSize = 1
+	case TagNewPort:
+		creationSize = 4
+	default:
+		return Port{}, TagError{Expected: TagNewPort, Got: tag}
+	}
+
+	node, err := readAtom(r)
+	if err != nil {
+		return Port{}, err
+	}
+	data := make([]byte, 4+creationSize)
+	if err := readFull(r, data); err != nil {
+		return Port{}, err
+	}
+
+	port := Port{Node: node, ID: binary.BigEndian.Uint32(data[0:4])}
+	if creationSize == 1 {
+		port.Creation = uint32(data[4])
+	} else {
+		port.Creation = binary.BigEndian.Uint32(data[4:8])
+	}
+	return port, nil
+}
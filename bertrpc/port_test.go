@@ -0,0 +1,53 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+// [#Port<0.1>, #Port<0.2>], with a PORT_EXT and a NEW_PORT_EXT, like erlang:ports() in a mixed cluster
+var portsBytes = []byte{131, 108, 0, 0, 0, 2,
+	102, 100, 0, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116, 0, 0, 0, 1, 3,
+	89, 119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116, 0, 0, 0, 2, 0, 0, 0, 0,
+	106}
+
+func TestDecodePort(t *testing.T) {
+	want := []bertrpc.Port{{Node: "nonode@nohost", ID: 1, Creation: 3}, {Node: "nonode@nohost", ID: 2}}
+
+	var ports []bertrpc.Port
+	if err := bertrpc.Decode(bytes.NewBuffer(portsBytes), &ports); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(ports, want) {
+		t.Errorf("incorrect ports: %#v (!= %#v)", ports, want)
+	}
+
+	// Ports do not prevent decoding the enclosing term
+	var term interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(portsBytes), &term); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if expected := bertrpc.L(want[0], want[1]); !reflect.DeepEqual(term, expected) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", term, expected)
+	}
+	if s := want[1].String(); s != "#Port<nonode@nohost.2>" {
+		t.Errorf("incorrect port string: %s", s)
+	}
+}
+
+func TestEncodePort(t *testing.T) {
+	port := bertrpc.Port{Node: "nonode@nohost", ID: 2}
+	data, err := bertrpc.Encode(port)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := append([]byte{131}, portsBytes[28:52]...); !bytes.Equal(data, expected) {
+		t.Errorf("EncodePort: expected %v, actual %v", expected, data)
+	}
+}
@@ -0,0 +1,99 @@
+package bertrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Reference is an Erlang reference, as returned by make_ref/0 or erlang:monitor/2.
+// ID holds the words identifying the reference, least significant first, as they are sent on the wire.
+type Reference struct {
+	Node     string
+	Creation uint32
+	ID       []uint32
+}
+
+func (ref Reference) String() string {
+	words := make([]string, len(ref.ID))
+	for i, word := range ref.ID {
+		// The Erlang shell shows the most significant word first
+		words[len(ref.ID)-1-i] = fmt.Sprint(word)
+	}
+	return fmt.Sprintf("#Ref<%s.%s>", ref.Node, strings.Join(words, "."))
+}
+
+// References are always encoded as NEWER_REFERENCE_EXT, with a 32 bits creation.
+func encodeReference(buf termWriter, ref Reference) error {
+	if len(ref.ID) > 0xffff {
+		return fmt.Errorf("cannot encode a reference with %d ID words", len(ref.ID))
+	}
+	buf.WriteByte(TagNewerReference)
+	if err := binary.Write(buf, binary.BigEndian, uint16(len(ref.ID))); err != nil {
+		return err
+	}
+	if err := encodeAtom(buf, ref.Node); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.BigEndian, ref.Creation); err != nil {
+		return err
+	}
+	return binary.Write(buf, binary.BigEndian, ref.ID)
+}
+
+func decodeReference(r io.Reader, val reflect.Value) error {
+	// Read Tag
+	byte1 := make([]byte, 1)
+	err := readFull(r, byte1)
+	if err != nil {
+		return err
+	}
+
+	ref, err := decodeReferenceBody(r, int(byte1[0]))
+	if err == nil {
+		val.Set(reflect.ValueOf(ref))
+	}
+	return err
+}
+
+// decodeReferenceBody decodes a reference whose tag has already been read.
+// NEW_REFERENCE_EXT has a creation on 1 byte, while NEWER_REFERENCE_EXT has it on 4 bytes.
+func decodeReferenceBody(r io.Reader, tag int) (Reference, error) {
+	var creationSize int
+	switch tag {
+	case TagNewReference:
+		creationSize = 1
+	case TagNewerReference:
+		creationSize = 4
+	default:
+		return Reference{}, TagError{Expected: TagNewerReference, Got: tag}
+	}
+
+	byte2 := make([]byte, 2)
+	if err := readFull(r, byte2); err != nil {
+		return Reference{}, err
+	}
+	length := int(binary.BigEndian.Uint16(byte2))
+
+	node, err := readAtom(r)
+	if err != nil {
+		return Reference{}, err
+	}
+	data := make([]byte, creationSize+4*length)
+	if err := readFull(r, data); err != nil {
+		return Reference{}, err
+	}
+
+	ref := Reference{Node: node, ID: make([]uint32, length)}
+	if creationSize == 1 {
+		ref.Creation = uint32(data[0])
+	} else {
+		ref.Creation = binary.BigEndian.Uint32(data[0:4])
+	}
+	for i := range ref.ID {
+		ref.ID[i] = binary.BigEndian.Uint32(data[creationSize+4*i:])
+	}
+	return ref, nil
+}
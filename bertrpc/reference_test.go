@@ -0,0 +1,82 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestEncodeReference(t *testing.T) {
+	ref := bertrpc.Reference{Node: "a@b", Creation: 7, ID: []uint32{1, 2, 3}}
+	data, err := bertrpc.Encode(ref)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []byte{131, 90, 0, 3, 119, 3, 97, 64, 98, 0, 0, 0, 7, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeReference: expected %v, actual %v", expected, data)
+	}
+
+	var decoded bertrpc.Reference
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(decoded, ref) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, ref)
+	}
+}
+
+func TestDecodeReference(t *testing.T) {
+	ref := bertrpc.Reference{Node: "nonode@nohost", ID: []uint32{212422, 1786773505, 1993399433}}
+	tests := []struct {
+		name  string
+		input []byte
+		want  bertrpc.Reference
+	}{
+		// #Ref<0.1993399433.1786773505.212422>, as term_to_binary(make_ref()) encodes it since OTP 23
+		{name: "NEWER_REFERENCE_EXT", input: []byte{131, 90, 0, 3,
+			119, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116,
+			0, 0, 0, 0, 0, 3, 61, 198, 106, 128, 0, 1, 118, 208, 220, 137}, want: ref},
+		// The same reference before OTP 23, with a creation on a single byte
+		{name: "NEW_REFERENCE_EXT", input: []byte{131, 114, 0, 3,
+			100, 0, 13, 110, 111, 110, 111, 100, 101, 64, 110, 111, 104, 111, 115, 116,
+			2, 0, 3, 61, 198, 106, 128, 0, 1, 118, 208, 220, 137},
+			want: bertrpc.Reference{Node: ref.Node, Creation: 2, ID: ref.ID}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var decoded bertrpc.Reference
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &decoded); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if !reflect.DeepEqual(decoded, tc.want) {
+				st.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, tc.want)
+			}
+		})
+	}
+	if s := ref.String(); s != "#Ref<nonode@nohost.1993399433.1786773505.212422>" {
+		t.Errorf("incorrect reference string: %s", s)
+	}
+
+	// {'DOWN', Ref, process, <0.80.0>, normal}, as sent to a monitoring process
+	input := append([]byte{131, 104, 5, 119, 4, 68, 79, 87, 78}, tests[0].input[1:]...)
+	input = append(input, 119, 7, 112, 114, 111, 99, 101, 115, 115)
+	input = append(input, pidBytes...)
+	input = append(input, 119, 6, 110, 111, 114, 109, 97, 108)
+	var down interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &down); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := bertrpc.T(bertrpc.Atom{Value: "DOWN"}, ref, bertrpc.Atom{Value: "process"},
+		bertrpc.Pid{Node: "nonode@nohost", ID: 80}, bertrpc.Atom{Value: "normal"})
+	if !reflect.DeepEqual(down, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", down, want)
+	}
+}
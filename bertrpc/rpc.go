@@ -8,23 +8,38 @@ import (
 // EncodeCall prepare a BERT-RPC Packet
 // See: http://bert-rpc.org/
 func EncodeCall(module string, function string, args ...interface{}) (bytes.Buffer, error) {
+	// -- {call, Module, Function, Arguments}
+	return encodeRequest("call", module, function, args)
+}
+
+// EncodeCast prepares a BERT-RPC cast packet, for a call whose result is not needed.
+// The server acknowledges it with {noreply}.
+func EncodeCast(module string, function string, args ...interface{}) (bytes.Buffer, error) {
+	// -- {cast, Module, Function, Arguments}
+	return encodeRequest("cast", module, function, args)
+}
+
+func encodeRequest(kind, module, function string, args []interface{}) (bytes.Buffer, error) {
 	var buf bytes.Buffer
+	err := encodePacket(&buf, T(A(kind), A(module), A(function), args))
+	return buf, err
+}
 
-	// -- {call, Module, Function, Arguments}
-	call := T(A("call"), A(module), A(function), args)
-	data, err := Encode(call)
+// encodePacket appends a term to buf, with its BERP header.
+func encodePacket(buf *bytes.Buffer, term interface{}) error {
+	data, err := Encode(term)
 	if err != nil {
-		return buf, err
+		return err
 	}
 
 	// BERP Header = 4-bytes length
 	// TODO: This should be optional for HTTP as it forces an extra allocation, instead of directly writing to the buffer
 	//       We already have packet framing at the HTTP call level.
-	if err := binary.Write(&buf, binary.BigEndian, uint32(len(data))); err != nil {
-		return buf, err
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
 	}
 
 	// Finally, write the data, after the length header
 	buf.Write(data)
-	return buf, err
+	return nil
 }
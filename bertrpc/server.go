@@ -0,0 +1,230 @@
+package bertrpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe once Close was called.
+var ErrServerClosed = errors.New("bert-rpc server closed")
+
+// ServerFunc is a Go function callable through BERT-RPC. It receives the arguments of the call, decoded like
+// when decoding into an interface{}, and returns the result to send back.
+// Returning an RPCError sends it as is; any other error is sent as a user error, and so is a panic.
+type ServerFunc func(args []interface{}) (interface{}, error)
+
+// Server serves BERT-RPC calls over TCP, with BERP framing, dispatching them to registered Go functions.
+// {call, Module, Function, Args} is answered with {reply, Result}, and {cast, Module, Function, Args}
+// with {noreply}, before the function runs. Calls on a connection are executed one after the other.
+type Server struct {
+	mu       sync.Mutex
+	modules  map[string]map[string]ServerFunc
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// Register makes the functions callable as Module:Function. Registering a module again replaces its functions.
+func (s *Server) Register(module string, fns map[string]func(args []interface{}) (interface{}, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.modules == nil {
+		s.modules = make(map[string]map[string]ServerFunc)
+	}
+	functions := make(map[string]ServerFunc, len(fns))
+	for name, fn := range fns {
+		functions[name] = fn
+	}
+	s.modules[module] = functions
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections it accepts.
+// It returns ErrServerClosed once Close was called.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted on l. It returns ErrServerClosed once Close was called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return ErrServerClosed
+		}
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(c)
+	}
+}
+
+// Close stops the server: it stops accepting connections, closes the open ones, and waits for the calls in
+// progress to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+		s.wg.Done()
+	}()
+
+	frames := NewFrameReader(c)
+	for {
+		var request Tuple
+		err := frames.Decode(&request)
+		if err == io.EOF || err == ErrTruncatedFrame {
+			return
+		}
+
+		var answer interface{}
+		var fn ServerFunc
+		var args []interface{}
+		if err != nil {
+			// The rest of the frame cannot be read when the connection is broken
+			if frames.Resync() != nil {
+				return
+			}
+			answer = rpcErrorTerm(RPCError{Type: "protocol", Code: 2, Class: "ProtocolError", Detail: err.Error()})
+		} else {
+			answer, fn, args = s.dispatch(request)
+		}
+
+		if err := WritePacket(c, answer); err != nil {
+			return
+		}
+		// The result of a cast is not sent
+		if fn != nil {
+			runFunc(fn, args)
+		}
+	}
+}
+
+// requestArgs returns the arguments of a request. Erlang encodes a list of small integers, like
+// [1, 2, 3], as STRING_EXT, and it decodes to a string.
+func requestArgs(term interface{}) ([]interface{}, bool) {
+	s, ok := term.(string)
+	if !ok {
+		args, ok := term.([]interface{})
+		return args, ok
+	}
+	args := []interface{}{}
+	for _, c := range s {
+		args = append(args, int64(c))
+	}
+	return args, true
+}
+
+// dispatch returns the answer to a request. For a cast, it also returns the function to run
+// once the request is acknowledged.
+func (s *Server) dispatch(request Tuple) (answer interface{}, cast ServerFunc, args []interface{}) {
+	if len(request.Elems) != 4 {
+		return rpcErrorTerm(RPCError{Type: "protocol", Code: 0, Class: "ProtocolError",
+			Detail: fmt.Sprintf("invalid request %v", request)}), nil, nil
+	}
+	kind, _ := atomText(request.Elems[0])
+	module, _ := atomText(request.Elems[1])
+	function, _ := atomText(request.Elems[2])
+	args, ok := requestArgs(request.Elems[3])
+	if kind != "call" && kind != "cast" || !ok {
+		return rpcErrorTerm(RPCError{Type: "protocol", Code: 0, Class: "ProtocolError",
+			Detail: fmt.Sprintf("invalid request %v", request)}), nil, nil
+	}
+
+	s.mu.Lock()
+	functions, moduleFound := s.modules[module]
+	fn, found := functions[function]
+	s.mu.Unlock()
+	switch {
+	case !moduleFound:
+		return rpcErrorTerm(RPCError{Type: "server", Code: 1, Class: "ServerError",
+			Detail: fmt.Sprintf("no such module %s", module)}), nil, nil
+	case !found:
+		return rpcErrorTerm(RPCError{Type: "server", Code: 2, Class: "ServerError",
+			Detail: fmt.Sprintf("no such function %s:%s", module, function)}), nil, nil
+	}
+
+	if kind == "cast" {
+		return T(A("noreply")), fn, args
+	}
+	result, err := runFunc(fn, args)
+	if err != nil {
+		rpcErr, ok := err.(RPCError)
+		if !ok {
+			rpcErr = RPCError{Type: "user", Code: 100, Class: reflect.TypeOf(err).String(), Detail: err.Error()}
+		}
+		return rpcErrorTerm(rpcErr), nil, nil
+	}
+	return T(A("reply"), result), nil, nil
+}
+
+// runFunc runs fn, returning a panic as a user error with code 0, so that a failing function does not
+// bring the server down.
+func runFunc(fn ServerFunc, args []interface{}) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = RPCError{Type: "user", Code: 0, Class: reflect.TypeOf(r).String(), Detail: fmt.Sprint(r)}
+		}
+	}()
+	return fn(args)
+}
+
+// rpcErrorTerm returns the {error, {Type, Code, Class, Detail, Backtrace}} answer for an RPCError.
+func rpcErrorTerm(e RPCError) Tuple {
+	backtrace := make([]interface{}, len(e.Backtrace))
+	for i, line := range e.Backtrace {
+		backtrace[i] = line
+	}
+	return T(A("error"), T(A(e.Type), e.Code, e.Class, e.Detail, backtrace))
+}
@@ -0,0 +1,133 @@
+package bertrpc_test
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+
+	var server bertrpc.Server
+	notified := make(chan interface{}, 1)
+	server.Register("calc", map[string]func(args []interface{}) (interface{}, error){
+		"add": func(args []interface{}) (interface{}, error) {
+			return args[0].(int64) + args[1].(int64), nil
+		},
+		"div": func(args []interface{}) (interface{}, error) {
+			if args[1].(int64) == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return args[0].(int64) / args[1].(int64), nil
+		},
+		"notify": func(args []interface{}) (interface{}, error) {
+			notified <- args[0]
+			return nil, nil
+		},
+	})
+	done := make(chan error)
+	go func() {
+		done <- server.Serve(l)
+	}()
+
+	client, err := bertrpc.Dial(l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Close()
+
+	if result, err := client.Call("calc", "add", 40, 2); err != nil || result != int64(42) {
+		t.Errorf("incorrect result: %#v (%v)", result, err)
+	}
+
+	errorTests := []struct {
+		module, function string
+		want             bertrpc.RPCError
+	}{
+		{module: "calc", function: "div", want: bertrpc.RPCError{Type: "user", Code: 100, Class: "*errors.errorString",
+			Detail: "division by zero", Backtrace: []string{}}},
+		{module: "calc", function: "mul", want: bertrpc.RPCError{Type: "server", Code: 2, Class: "ServerError",
+			Detail: "no such function calc:mul", Backtrace: []string{}}},
+		{module: "math", function: "add", want: bertrpc.RPCError{Type: "server", Code: 1, Class: "ServerError",
+			Detail: "no such module math", Backtrace: []string{}}},
+	}
+	for _, tc := range errorTests {
+		_, err := client.Call(tc.module, tc.function, 1, 0)
+		rpcErr, ok := err.(bertrpc.RPCError)
+		if !ok || !reflect.DeepEqual(rpcErr, tc.want) {
+			t.Errorf("incorrect error for %s:%s: %#v (!= %#v)", tc.module, tc.function, err, tc.want)
+		}
+	}
+
+	// The functions assert the type of their arguments: a panic is returned as an error
+	_, err = client.Call("calc", "add", "a", 1)
+	if rpcErr, ok := err.(bertrpc.RPCError); !ok || rpcErr.Type != "user" || rpcErr.Code != 0 {
+		t.Errorf("a panicking function should return a user error: %#v", err)
+	}
+	if err := client.Cast("calc", "add", "a", 1); err != nil {
+		t.Error(err)
+	}
+
+	if err := client.Cast("calc", "notify", "hello"); err != nil {
+		t.Error(err)
+	}
+	if arg := <-notified; arg != "hello" {
+		t.Errorf("incorrect cast argument: %#v", arg)
+	}
+
+	// {call, calc, add, [40, 2]} from term_to_binary, with the arguments encoded as STRING_EXT
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	request := []byte{0, 0, 0, 28, 131, 104, 4, 100, 0, 4, 99, 97, 108, 108, 100, 0, 4, 99, 97, 108, 99,
+		100, 0, 3, 97, 100, 100, 107, 0, 2, 40, 2}
+	if _, err := conn.Write(request); err != nil {
+		t.Error(err)
+		return
+	}
+	var result int
+	if err := bertrpc.DecodeReply(conn, &result); err != nil || result != 42 {
+		t.Errorf("incorrect result for STRING_EXT arguments: %d (%v)", result, err)
+	}
+
+	if err := bertrpc.WritePacket(conn, bertrpc.T(bertrpc.A("call"), bertrpc.A("calc"), bertrpc.A("add"), 42)); err != nil {
+		t.Error(err)
+		return
+	}
+	if err, ok := bertrpc.DecodeReply(conn, &result).(bertrpc.RPCError); !ok || err.Type != "protocol" || err.Code != 0 {
+		t.Errorf("an invalid request should return a protocol error with code 0: %#v", err)
+	}
+
+	// A tuple announcing 4294967295 elements in a 6 byte frame is reported as unreadable
+	if _, err := conn.Write([]byte{0, 0, 0, 6, 131, 105, 255, 255, 255, 255}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err, ok := bertrpc.DecodeReply(conn, &result).(bertrpc.RPCError); !ok || err.Type != "protocol" || err.Code != 2 {
+		t.Errorf("a truncated request should return a protocol error: %#v", err)
+	}
+	if result, err := client.Call("calc", "add", 1, 1); err != nil || result != int64(2) {
+		t.Errorf("incorrect result after a truncated request: %#v (%v)", result, err)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := <-done; err != bertrpc.ErrServerClosed {
+		t.Errorf("Serve should return ErrServerClosed after Close, not %v", err)
+	}
+	if _, err := client.Call("calc", "add", 1, 1); err == nil {
+		t.Errorf("calling a closed server should fail")
+	}
+}
@@ -0,0 +1,90 @@
+package bertrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ============================================================================
+// Erlang sets
+
+// DecodeOrdset decodes an Erlang ordset, a list of unique terms sorted in Erlang term order,
+// into a Go set. Elements are decoded like when decoding into an interface{}, and must be
+// usable as map keys. When strict is true, a list that is not sorted or contains duplicates
+// is rejected, as ordsets:is_set/1 would.
+func DecodeOrdset(r io.Reader, strict bool) (map[interface{}]struct{}, error) {
+	r, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return nil, err
+	}
+
+	var elems []interface{}
+	switch tag := int(byte1[0]); tag {
+	case TagString:
+		// Erlang sends lists of small integers as STRING_EXT
+		data, err := decodeString2(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range data {
+			elems = append(elems, int64(b))
+		}
+	case TagNil, TagList:
+		term, err := decodeDynamicBody(r, tag)
+		if err != nil {
+			return nil, err
+		}
+		list, ok := term.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot decode improper list %v to ordset", term)
+		}
+		elems = list
+	default:
+		return nil, TagError{Expected: TagList, Got: tag}
+	}
+
+	set := make(map[interface{}]struct{}, len(elems))
+	for i, elem := range elems {
+		if strict && i > 0 && compareTerms(elems[i-1], elem) >= 0 {
+			return nil, fmt.Errorf("not an ordset: %v does not come after %v", elem, elems[i-1])
+		}
+		if elem != nil && !reflect.TypeOf(elem).Comparable() {
+			return nil, fmt.Errorf("cannot use %s as a set element", reflect.TypeOf(elem))
+		}
+		set[elem] = struct{}{}
+	}
+	return set, nil
+}
+
+// isSet tells if a Go map type is a set, like map[string]struct{}.
+func isSet(t reflect.Type) bool {
+	return t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+// encodeSet encodes a Go set as an ordset: the list of its elements, sorted in Erlang term order.
+func encodeSet(buf termWriter, v reflect.Value) error {
+	keys, err := sortedMapKeys(v)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		buf.WriteByte(TagNil)
+		return nil
+	}
+
+	buf.WriteByte(TagList)
+	if err := binary.Write(buf, binary.BigEndian, int32(len(keys))); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		buf.Write(k.data)
+	}
+	buf.WriteByte(TagNil)
+	return nil
+}
@@ -0,0 +1,101 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestDecodeOrdset(t *testing.T) {
+	a, b := bertrpc.Atom{Value: "a"}, bertrpc.Atom{Value: "b"}
+	tests := []struct {
+		name  string
+		input []byte
+		want  map[interface{}]struct{}
+	}{
+		{name: "[]", input: []byte{131, 106}, want: map[interface{}]struct{}{}},
+		{name: "[a, b]", input: []byte{131, 108, 0, 0, 0, 2, 119, 1, 97, 119, 1, 98, 106},
+			want: map[interface{}]struct{}{a: {}, b: {}}},
+		{name: "[1, 2, 3]", input: []byte{131, 107, 0, 3, 1, 2, 3},
+			want: map[interface{}]struct{}{int64(1): {}, int64(2): {}, int64(3): {}}},
+		{name: "[1, a, <<\"b\">>]", input: []byte{131, 108, 0, 0, 0, 3, 97, 1, 119, 1, 97, 109, 0, 0, 0, 1, 98, 106},
+			want: map[interface{}]struct{}{int64(1): {}, a: {}, "b": {}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			set, err := bertrpc.DecodeOrdset(bytes.NewBuffer(tc.input), true)
+			if err != nil {
+				st.Errorf("cannot decode ordset: %s", err)
+				return
+			}
+			if !reflect.DeepEqual(set, tc.want) {
+				st.Errorf("incorrect set: %#v (!= %#v)", set, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeOrdsetNotSorted(t *testing.T) {
+	// [b, a, a]
+	input := []byte{131, 108, 0, 0, 0, 3, 119, 1, 98, 119, 1, 97, 119, 1, 97, 106}
+
+	if _, err := bertrpc.DecodeOrdset(bytes.NewBuffer(input), true); err == nil {
+		t.Errorf("decoding an unsorted list as a strict ordset should fail")
+	}
+
+	set, err := bertrpc.DecodeOrdset(bytes.NewBuffer(input), false)
+	if err != nil {
+		t.Errorf("cannot decode ordset: %s", err)
+		return
+	}
+	want := map[interface{}]struct{}{bertrpc.Atom{Value: "a"}: {}, bertrpc.Atom{Value: "b"}: {}}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("incorrect set: %#v (!= %#v)", set, want)
+	}
+}
+
+func TestDecodeOrdsetImproperList(t *testing.T) {
+	// [a | b]
+	input := []byte{131, 108, 0, 0, 0, 1, 119, 1, 97, 119, 1, 98}
+
+	if _, err := bertrpc.DecodeOrdset(bytes.NewBuffer(input), false); err == nil {
+		t.Errorf("decoding an improper list as an ordset should fail")
+	}
+}
+
+// Go sets are encoded as ordsets, so they round-trip through DecodeOrdset.
+func TestEncodeSet(t *testing.T) {
+	set := map[string]struct{}{"c": {}, "a": {}, "b": {}}
+
+	data, err := bertrpc.Encode(set)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// [<<"a">>, <<"b">>, <<"c">>]
+	expected := []byte{131, 108, 0, 0, 0, 3, 109, 0, 0, 0, 1, 97, 109, 0, 0, 0, 1, 98, 109, 0, 0, 0, 1, 99, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeSet: expected %v, actual %v", expected, data)
+	}
+
+	decoded, err := bertrpc.DecodeOrdset(bytes.NewBuffer(data), true)
+	if err != nil {
+		t.Errorf("cannot decode ordset: %s", err)
+		return
+	}
+	want := map[interface{}]struct{}{"a": {}, "b": {}, "c": {}}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect set: %#v (!= %#v)", decoded, want)
+	}
+
+	data, err = bertrpc.Encode(map[int]struct{}{})
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := []byte{131, 106}; !bytes.Equal(data, expected) {
+		t.Errorf("EncodeSet: expected %v, actual %v", expected, data)
+	}
+}
@@ -0,0 +1,166 @@
+package bertrpc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A Decoder reads and decodes successive Erlang terms from an input stream.
+// The Decoder buffers its input, so it may read data beyond the terms it decodes.
+type Decoder struct {
+	r    *bufio.Reader
+	opts decodeOptions
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next term, starting with its version tag, and stores it in the value pointed to by term.
+func (d *Decoder) Decode(term interface{}) error {
+	r, err := readHeader(d.r)
+	if err != nil {
+		return err
+	}
+	return decodeData(&optionsReader{Reader: r, decodeOptions: d.opts}, term)
+}
+
+// DisallowNonCanonicalIntegers causes the Decoder to return ErrNotCanonical when an integer does not use its
+// smallest representation, as CanonicalMarshal does: an INTEGER_EXT holding a value that fits in a
+// SMALL_INTEGER_EXT, or a SMALL_BIG_EXT holding a value that fits in an INTEGER_EXT.
+func (d *Decoder) DisallowNonCanonicalIntegers() {
+	d.opts.canonicalIntegers = true
+}
+
+// CollectAtoms causes the Decoder to record every distinct atom found in the terms it decodes,
+// without changing the decoded values. They can then be listed with SeenAtoms.
+// It helps discovering the records and atoms a peer sends.
+func (d *Decoder) CollectAtoms() {
+	if d.opts.seenAtoms == nil {
+		d.opts.seenAtoms = make(map[string]struct{})
+	}
+}
+
+// SeenAtoms returns the atoms found since CollectAtoms was called, sorted.
+func (d *Decoder) SeenAtoms() []string {
+	atoms := make([]string, 0, len(d.opts.seenAtoms))
+	for atom := range d.opts.seenAtoms {
+		atoms = append(atoms, atom)
+	}
+	sort.Strings(atoms)
+	return atoms
+}
+
+// Peek returns the tag of the next term, without consuming any input.
+// It lets the caller choose the target type before decoding the term. The tag of a compressed term is
+// the tag of the term once uncompressed, which is read from the start of the compressed data: that start
+// must fit in the buffer of the Decoder.
+func (d *Decoder) Peek() (int, error) {
+	data, err := d.r.Peek(2)
+	if len(data) == 1 && err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if data[0] != TagETFVersion {
+		return 0, fmt.Errorf("incorrect Erlang Term version tag: %d", data[0])
+	}
+	if data[1] == TagCompressed {
+		return d.peekCompressed()
+	}
+	return int(data[1]), nil
+}
+
+// peekCompressed uncompresses the tag of the compressed term starting the input, leaving the input untouched.
+func (d *Decoder) peekCompressed() (int, error) {
+	// Version tag, compression tag, then the uncompressed size on 4 bytes
+	zr, err := zlib.NewReader(&peekReader{r: d.r, n: 6})
+	if err != nil {
+		return 0, err
+	}
+	tag := make([]byte, 1)
+	if err := readFull(zr, tag); err != nil {
+		return 0, err
+	}
+	return int(tag[0]), nil
+}
+
+// peekReader reads the data buffered by a bufio.Reader from offset n, without consuming it.
+// It only waits for more input once all the buffered data was read.
+type peekReader struct {
+	r *bufio.Reader
+	n int
+}
+
+func (p *peekReader) Read(b []byte) (int, error) {
+	if _, err := p.r.Peek(p.n + 1); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	data, _ := p.r.Peek(p.r.Buffered())
+	n := copy(b, data[p.n:])
+	p.n += n
+	return n, nil
+}
+
+// An Encoder writes successive Erlang terms to an output stream.
+// Each term is encoded in memory first, then written with a single call to Write: a term is never
+// interleaved with other writes, which matters for framed protocols and connections shared by goroutines
+// that serialize their calls to Encode.
+type Encoder struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the version tag and the encoding of term to the stream.
+// Nothing is written when term cannot be encoded. A short write returns io.ErrShortWrite.
+func (e *Encoder) Encode(term interface{}) error {
+	e.buf.Reset()
+	if err := encodeTermTo(term, &e.buf); err != nil {
+		return err
+	}
+	n, err := e.w.Write(e.buf.Bytes())
+	if err == nil && n < e.buf.Len() {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// decodeOptions tune how terms are decoded.
+// They travel with the reader passed down the decoding functions, wrapped in an optionsReader.
+type decodeOptions struct {
+	canonicalIntegers bool
+	// stringsAsLists decodes STRING_EXT as a list of integers instead of a string, without a target type.
+	stringsAsLists bool
+	// mapsAsEntries decodes maps as a []MapEntry instead of a Go map, without a target type.
+	mapsAsEntries bool
+	// seenAtoms collects the atoms read, when not nil.
+	seenAtoms map[string]struct{}
+}
+
+type optionsReader struct {
+	io.Reader
+	decodeOptions
+}
+
+// optionsOf returns the decoding options attached to a reader. Plain readers use the default options.
+func optionsOf(r io.Reader) decodeOptions {
+	if o, ok := r.(*optionsReader); ok {
+		return o.decodeOptions
+	}
+	return decodeOptions{}
+}
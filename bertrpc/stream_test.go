@@ -0,0 +1,203 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+// A Decoder keeps reading from the same connection, one term after the other.
+func TestDecoderConn(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		defer client.Close()
+		for i := 0; i < 3; i++ {
+			data, _ := bertrpc.Encode(bertrpc.T(bertrpc.A("ping"), i))
+			// Terms split across writes are read as a whole
+			client.Write(data[:3])
+			client.Write(data[3:])
+		}
+	}()
+
+	dec := bertrpc.NewDecoder(server)
+	for i := 0; i < 3; i++ {
+		var ping struct {
+			Name bertrpc.Atom
+			Seq  int
+		}
+		if err := dec.Decode(&ping); err != nil {
+			t.Errorf("cannot decode Erlang term: %s", err)
+			return
+		}
+		if ping.Name.Value != "ping" || ping.Seq != i {
+			t.Errorf("incorrect decoded value: %#v", ping)
+		}
+	}
+	var term interface{}
+	if err := dec.Decode(&term); err != io.EOF {
+		t.Errorf("decoding at the end of the stream should return io.EOF: %v", err)
+	}
+}
+
+func TestDecoderPeek(t *testing.T) {
+	// {ok, 42}, then 7
+	input := []byte{131, 104, 2, 119, 2, 111, 107, 97, 42, 131, 97, 7}
+	dec := bertrpc.NewDecoder(bytes.NewReader(input))
+
+	tag, err := dec.Peek()
+	if err != nil {
+		t.Errorf("cannot peek next term: %s", err)
+		return
+	}
+	if tag != bertrpc.TagSmallTuple {
+		t.Errorf("incorrect tag: %d (!= %d)", tag, bertrpc.TagSmallTuple)
+	}
+
+	// Peek does not consume the term
+	var result struct {
+		Tag   string `erlang:"tag"`
+		Value int    `erlang:"tag:ok"`
+	}
+	if err := dec.Decode(&result); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if result.Tag != "ok" || result.Value != 42 {
+		t.Errorf("incorrect decoded value: %#v", result)
+	}
+
+	tag, err = dec.Peek()
+	if err != nil {
+		t.Errorf("cannot peek next term: %s", err)
+		return
+	}
+	if tag != bertrpc.TagSmallInteger {
+		t.Errorf("incorrect tag: %d (!= %d)", tag, bertrpc.TagSmallInteger)
+	}
+	var i int
+	if err := dec.Decode(&i); err != nil || i != 7 {
+		t.Errorf("incorrect decoded value: %d (%v)", i, err)
+	}
+
+	if _, err := dec.Peek(); err != io.EOF {
+		t.Errorf("peeking at the end of the stream should return io.EOF: %v", err)
+	}
+}
+
+func TestDecoderDisallowNonCanonicalIntegers(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []byte
+		canonical bool
+	}{
+		{name: "42 as SMALL_INTEGER_EXT", input: []byte{131, 97, 42}, canonical: true},
+		{name: "42 as INTEGER_EXT", input: []byte{131, 98, 0, 0, 0, 42}},
+		{name: "-1 as INTEGER_EXT", input: []byte{131, 98, 255, 255, 255, 255}, canonical: true},
+		{name: "2^31 as SMALL_BIG_EXT", input: []byte{131, 110, 4, 0, 0, 0, 0, 128}, canonical: true},
+		{name: "256 as SMALL_BIG_EXT", input: []byte{131, 110, 2, 0, 0, 1}},
+		{name: "2^31 with a leading zero digit", input: []byte{131, 110, 5, 0, 0, 0, 0, 128, 0}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var i int64
+			// Lenient by default
+			if err := bertrpc.NewDecoder(bytes.NewReader(tc.input)).Decode(&i); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+			}
+
+			dec := bertrpc.NewDecoder(bytes.NewReader(tc.input))
+			dec.DisallowNonCanonicalIntegers()
+			err := dec.Decode(&i)
+			if tc.canonical && err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+			}
+			if !tc.canonical && err != bertrpc.ErrNotCanonical {
+				st.Errorf("non canonical integer should be rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecoderSeenAtoms(t *testing.T) {
+	// {user, joe, [admin, ops], #{role => admin}}, then {ok, <<"done">>}
+	input := []byte{131, 104, 4, 119, 4, 117, 115, 101, 114, 100, 0, 3, 106, 111, 101,
+		108, 0, 0, 0, 2, 119, 5, 97, 100, 109, 105, 110, 119, 3, 111, 112, 115, 106,
+		116, 0, 0, 0, 1, 119, 4, 114, 111, 108, 101, 119, 5, 97, 100, 109, 105, 110,
+		131, 104, 2, 119, 2, 111, 107, 109, 0, 0, 0, 4, 100, 111, 110, 101}
+	dec := bertrpc.NewDecoder(bytes.NewReader(input))
+	dec.CollectAtoms()
+
+	var term interface{}
+	if err := dec.Decode(&term); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	admin := bertrpc.Atom{Value: "admin"}
+	want := bertrpc.T(bertrpc.Atom{Value: "user"}, bertrpc.Atom{Value: "joe"},
+		bertrpc.L(admin, bertrpc.Atom{Value: "ops"}), map[interface{}]interface{}{bertrpc.Atom{Value: "role"}: admin})
+	if !reflect.DeepEqual(term, want) {
+		t.Errorf("incorrect decoded term: %#v (!= %#v)", term, want)
+	}
+
+	// Atoms decoded into typed targets are collected too
+	var result struct {
+		Tag    string `erlang:"tag"`
+		Result string `erlang:"tag:ok"`
+	}
+	if err := dec.Decode(&result); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+
+	atoms := dec.SeenAtoms()
+	if expected := []string{"admin", "joe", "ok", "ops", "role", "user"}; !reflect.DeepEqual(atoms, expected) {
+		t.Errorf("incorrect seen atoms: %v (!= %v)", atoms, expected)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var w recordWriter
+	enc := bertrpc.NewEncoder(&w)
+	terms := []interface{}{bertrpc.T(bertrpc.A("ping")), bertrpc.L(1, 2, 3), bytes.Repeat([]byte{1}, 1<<16)}
+	var expected []byte
+	for _, term := range terms {
+		if err := enc.Encode(term); err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ := bertrpc.Encode(term)
+		expected = append(expected, data...)
+	}
+	if !bytes.Equal(w.Bytes(), expected) {
+		t.Errorf("Encoder: expected %v, actual %v", expected, w.Bytes())
+	}
+	// One write per term
+	if len(w.writes) != len(terms) {
+		t.Errorf("Encoder: %d writes for %d terms", len(w.writes), len(terms))
+	}
+
+	// Terms that cannot be encoded are not written.
+	if err := enc.Encode(make(chan int)); err == nil {
+		t.Errorf("encoding a channel should fail")
+	}
+	if w.Len() != len(expected) {
+		t.Errorf("Encoder: %d bytes written for a term that cannot be encoded", w.Len()-len(expected))
+	}
+
+	enc = bertrpc.NewEncoder(shortWriter{})
+	if err := enc.Encode(42); err != io.ErrShortWrite {
+		t.Errorf("Encoder: a short write should return io.ErrShortWrite, not %v", err)
+	}
+}
+
+// shortWriter writes a single byte at a time, without reporting an error.
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) {
+	return 1, nil
+}
@@ -0,0 +1,282 @@
+package bertrpc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// ============================================================================
+// Timeout
+
+// Timeout is an Erlang timeout, as used by gen_server or receive: a number of milliseconds, or the atom infinity.
+// The zero value is a timeout of 0 milliseconds.
+type Timeout struct {
+	ms       int64
+	infinity bool
+}
+
+// Infinity is the timeout that never expires.
+var Infinity = Timeout{infinity: true}
+
+// TimeoutMillis returns a timeout expiring after the given number of milliseconds.
+func TimeoutMillis(ms int64) Timeout {
+	return Timeout{ms: ms}
+}
+
+// IsInfinity tells if the timeout never expires.
+func (t Timeout) IsInfinity() bool {
+	return t.infinity
+}
+
+// Milliseconds returns the timeout in milliseconds. It is meaningless for Infinity.
+func (t Timeout) Milliseconds() int64 {
+	return t.ms
+}
+
+func (t Timeout) String() string {
+	if t.infinity {
+		return "infinity"
+	}
+	return fmt.Sprintf("%dms", t.ms)
+}
+
+// MarshalBERT encodes the timeout as the atom infinity, or as an integer.
+func (t Timeout) MarshalBERT() ([]byte, error) {
+	if t.infinity {
+		return Encode(A("infinity"))
+	}
+	return Encode(t.ms)
+}
+
+// UnmarshalBERT decodes the atom infinity, or a non negative integer.
+func (t *Timeout) UnmarshalBERT(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := readVersion(r); err != nil {
+		return err
+	}
+	tag, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch int(tag) {
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		atom, err := readAtomBody(r, int(tag))
+		if err != nil {
+			return err
+		}
+		if atom != "infinity" {
+			return fmt.Errorf("cannot decode atom %s to timeout", atom)
+		}
+		*t = Infinity
+		return nil
+	default:
+		ms, err := decodeIntBody(r, int(tag))
+		if err != nil {
+			return err
+		}
+		if ms < 0 {
+			return fmt.Errorf("negative timeout: %d", ms)
+		}
+		*t = TimeoutMillis(ms)
+		return nil
+	}
+}
+
+// ============================================================================
+// Durations
+
+// Erlang time units, as used by erlang:convert_time_unit/3 or erlang:system_time/1.
+// The plural forms are deprecated in Erlang, but still accepted.
+var timeUnits = map[string]time.Duration{
+	"second":        time.Second,
+	"millisecond":   time.Millisecond,
+	"microsecond":   time.Microsecond,
+	"nanosecond":    time.Nanosecond,
+	"seconds":       time.Second,
+	"milli_seconds": time.Millisecond,
+	"micro_seconds": time.Microsecond,
+	"nano_seconds":  time.Nanosecond,
+}
+
+// timeUnit returns the duration of one unit of an Erlang time unit atom.
+func timeUnit(unit string) (time.Duration, error) {
+	d, ok := timeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown time unit: %s", unit)
+	}
+	return d, nil
+}
+
+// DecodeDurationTuple decodes a {Count, Unit} tuple, Unit being an Erlang time unit atom like second
+// or millisecond, into a time.Duration. For example, {5, second} is decoded as 5 * time.Second.
+func DecodeDurationTuple(r io.Reader) (time.Duration, error) {
+	r, err := readHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	length, err := readTupleInfo(r)
+	if err != nil {
+		return 0, err
+	}
+	if length != 2 {
+		return 0, fmt.Errorf("duration should be a {Count, Unit} tuple")
+	}
+
+	count, err := decodeInt(r)
+	if err != nil {
+		return 0, err
+	}
+	atom, err := readAtom(r)
+	if err != nil {
+		return 0, err
+	}
+	unit, err := timeUnit(atom)
+	if err != nil {
+		return 0, err
+	}
+
+	if count > math.MaxInt64/int64(unit) || count < math.MinInt64/int64(unit) {
+		return 0, ErrRange
+	}
+	return time.Duration(count) * unit, nil
+}
+
+// DurationIn is a time.Duration encoded with a given Erlang time unit, instead of the largest exact one.
+// For example, DurationIn{5 * time.Second, "millisecond"} is encoded as {5000, millisecond}.
+// The duration is truncated when it is not a whole number of units.
+type DurationIn struct {
+	Duration time.Duration
+	Unit     string
+}
+
+// MarshalBERT encodes the duration as a {Count, Unit} tuple.
+func (d DurationIn) MarshalBERT() ([]byte, error) {
+	unit, err := timeUnit(d.Unit)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(T(int64(d.Duration/unit), A(d.Unit)))
+}
+
+// encodeDuration encodes a duration as a {Count, Unit} tuple, using the largest unit that represents
+// it exactly. For example, 5 * time.Second is encoded as {5, second} and 1500 * time.Millisecond
+// as {1500, millisecond}.
+func encodeDuration(buf termWriter, d time.Duration) error {
+	unit := "nanosecond"
+	for _, u := range []string{"second", "millisecond", "microsecond"} {
+		if d%timeUnits[u] == 0 {
+			unit = u
+			break
+		}
+	}
+	return encodeTuple(buf, T(int64(d/timeUnits[unit]), A(unit)))
+}
+
+// ============================================================================
+// Erlang timestamps
+
+// encodeTimestamp encodes a time as an os:timestamp() tuple: {MegaSecs, Secs, MicroSecs} since the Unix epoch,
+// so that the time is MegaSecs * 1000000 + Secs seconds and MicroSecs microseconds after it.
+// Secs and MicroSecs are always between 0 and 999999, also before the epoch. Nanoseconds are truncated.
+func encodeTimestamp(buf termWriter, t time.Time) error {
+	sec := t.Unix()
+	mega, secs := sec/1e6, sec%1e6
+	if secs < 0 {
+		mega, secs = mega-1, secs+1e6
+	}
+	return encodeTuple(buf, T(mega, secs, t.Nanosecond()/1e3))
+}
+
+// DecodeTimestamp decodes an os:timestamp() {MegaSecs, Secs, MicroSecs} tuple into a time.Time.
+// It is the reverse of the encoding of time.Time, and also how time.Time is decoded. The time is in UTC.
+func DecodeTimestamp(r io.Reader) (time.Time, error) {
+	r, err := readHeader(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return decodeTimestamp(r)
+}
+
+func decodeTimestamp(r io.Reader) (time.Time, error) {
+	length, err := readTupleInfo(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if length != 3 {
+		return time.Time{}, fmt.Errorf("timestamp should be a {MegaSecs, Secs, MicroSecs} tuple")
+	}
+
+	var parts [3]int64
+	for i := range parts {
+		if parts[i], err = decodeInt(r); err != nil {
+			return time.Time{}, err
+		}
+	}
+	mega, secs, micros := parts[0], parts[1], parts[2]
+	if secs < 0 || secs >= 1e6 || micros < 0 || micros >= 1e6 {
+		return time.Time{}, fmt.Errorf("incorrect timestamp {%d, %d, %d}", mega, secs, micros)
+	}
+	if mega > math.MaxInt64/1000000-1 || mega < math.MinInt64/1000000 {
+		return time.Time{}, ErrRange
+	}
+	return time.Unix(mega*1e6+secs, micros*1e3).UTC(), nil
+}
+
+// UnixMillis is a time.Time encoded as an integer number of milliseconds since the Unix epoch,
+// like erlang:system_time(millisecond) returns, instead of an os:timestamp() tuple.
+// Sub-millisecond precision is truncated. Decoded times are in UTC.
+type UnixMillis time.Time
+
+// Time returns the time.Time value.
+func (t UnixMillis) Time() time.Time {
+	return time.Time(t)
+}
+
+// MarshalBERT encodes the time as an integer number of milliseconds.
+func (t UnixMillis) MarshalBERT() ([]byte, error) {
+	tt := time.Time(t)
+	ms := tt.Unix()*1e3 + int64(tt.Nanosecond()/1e6)
+	return Encode(ms)
+}
+
+// UnmarshalBERT decodes an integer number of milliseconds.
+func (t *UnixMillis) UnmarshalBERT(data []byte) error {
+	var ms int64
+	if err := Unmarshal(data, &ms); err != nil {
+		return err
+	}
+	*t = UnixMillis(time.Unix(ms/1e3, ms%1e3*1e6).UTC())
+	return nil
+}
+
+// ============================================================================
+// ISO 8601 timestamps
+
+// ISOTime is a time.Time encoded as an RFC 3339 binary, like <<"2020-01-02T15:04:05Z">>,
+// for Erlang services that handle timestamps as opaque strings.
+// Fractional seconds are only written when needed, so that decoding gives back the same instant.
+type ISOTime time.Time
+
+// Time returns the time.Time value.
+func (t ISOTime) Time() time.Time {
+	return time.Time(t)
+}
+
+// MarshalBinary returns the RFC 3339 representation of the time, encoded as an Erlang binary.
+func (t ISOTime) MarshalBinary() ([]byte, error) {
+	return []byte(time.Time(t).Format(time.RFC3339Nano)), nil
+}
+
+// UnmarshalBinary parses a time in RFC 3339 format, decoded from an Erlang binary.
+func (t *ISOTime) UnmarshalBinary(data []byte) error {
+	parsed, err := time.Parse(time.RFC3339, string(data))
+	if err != nil {
+		return err
+	}
+	*t = ISOTime(parsed)
+	return nil
+}
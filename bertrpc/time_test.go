@@ -0,0 +1,240 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  bertrpc.Timeout
+	}{
+		{name: "5000", input: []byte{131, 98, 0, 0, 19, 136}, want: bertrpc.TimeoutMillis(5000)},
+		{name: "infinity", input: []byte{131, 119, 8, 105, 110, 102, 105, 110, 105, 116, 121}, want: bertrpc.Infinity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			var timeout bertrpc.Timeout
+			if err := bertrpc.Decode(bytes.NewBuffer(tc.input), &timeout); err != nil {
+				st.Errorf("cannot decode Erlang term: %s", err)
+				return
+			}
+			if timeout.IsInfinity() != tc.want.IsInfinity() || timeout.Milliseconds() != tc.want.Milliseconds() {
+				st.Errorf("incorrect timeout: %v (!= %v)", timeout, tc.want)
+			}
+
+			data, err := bertrpc.Encode(timeout)
+			if err != nil {
+				st.Error(err)
+			}
+			if !bytes.Equal(data, tc.input) {
+				st.Errorf("incorrect encoded timeout: %v (!= %v)", data, tc.input)
+			}
+		})
+	}
+
+	// Only infinity is a valid atom timeout
+	var timeout bertrpc.Timeout
+	if err := bertrpc.Decode(bytes.NewBuffer([]byte{131, 119, 2, 111, 107}), &timeout); err == nil {
+		t.Errorf("decoding atom ok into a timeout should fail")
+	}
+}
+
+func TestDecodeDurationTuple(t *testing.T) {
+	tests := []struct {
+		unit string
+		want time.Duration
+	}{
+		{unit: "second", want: 5 * time.Second},
+		{unit: "millisecond", want: 5 * time.Millisecond},
+		{unit: "microsecond", want: 5 * time.Microsecond},
+		{unit: "nanosecond", want: 5 * time.Nanosecond},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.unit, func(st *testing.T) {
+			data, err := bertrpc.Encode(bertrpc.T(5, bertrpc.A(tc.unit)))
+			if err != nil {
+				st.Error(err)
+				return
+			}
+			d, err := bertrpc.DecodeDurationTuple(bytes.NewBuffer(data))
+			if err != nil {
+				st.Errorf("cannot decode duration: %s", err)
+				return
+			}
+			if d != tc.want {
+				st.Errorf("incorrect duration: %s (!= %s)", d, tc.want)
+			}
+		})
+	}
+
+	data, _ := bertrpc.Encode(bertrpc.T(5, bertrpc.A("fortnight")))
+	if _, err := bertrpc.DecodeDurationTuple(bytes.NewBuffer(data)); err == nil {
+		t.Errorf("decoding an unknown time unit should fail")
+	}
+}
+
+func TestEncodeDuration(t *testing.T) {
+	tests := []struct {
+		duration interface{}
+		want     bertrpc.Tuple
+	}{
+		{duration: 5 * time.Second, want: bertrpc.T(5, bertrpc.A("second"))},
+		{duration: 1500 * time.Millisecond, want: bertrpc.T(1500, bertrpc.A("millisecond"))},
+		{duration: -3 * time.Microsecond, want: bertrpc.T(-3, bertrpc.A("microsecond"))},
+		{duration: 1001 * time.Nanosecond, want: bertrpc.T(1001, bertrpc.A("nanosecond"))},
+		{duration: time.Duration(0), want: bertrpc.T(0, bertrpc.A("second"))},
+		{duration: bertrpc.DurationIn{Duration: 5 * time.Second, Unit: "millisecond"},
+			want: bertrpc.T(5000, bertrpc.A("millisecond"))},
+	}
+
+	for _, tc := range tests {
+		data, err := bertrpc.Encode(tc.duration)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		expected, _ := bertrpc.Encode(tc.want)
+		if !bytes.Equal(data, expected) {
+			t.Errorf("EncodeDuration %v: expected %v, actual %v", tc.duration, expected, data)
+		}
+
+		// Round-trip
+		d, err := bertrpc.DecodeDurationTuple(bytes.NewBuffer(data))
+		if err != nil {
+			t.Errorf("cannot decode duration: %s", err)
+			continue
+		}
+		want, ok := tc.duration.(time.Duration)
+		if !ok {
+			want = tc.duration.(bertrpc.DurationIn).Duration
+		}
+		if d != want {
+			t.Errorf("incorrect duration: %s (!= %s)", d, want)
+		}
+	}
+
+	if _, err := bertrpc.Encode(bertrpc.DurationIn{Duration: time.Second, Unit: "fortnight"}); err == nil {
+		t.Errorf("encoding a duration with an unknown time unit should fail")
+	}
+}
+
+func TestISOTime(t *testing.T) {
+	ts := time.Date(2020, time.January, 2, 15, 4, 5, 0, time.UTC)
+	data, err := bertrpc.Encode(bertrpc.ISOTime(ts))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := append([]byte{131, 109, 0, 0, 0, 20}, "2020-01-02T15:04:05Z"...)
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeISOTime: expected %v, actual %v", expected, data)
+	}
+
+	// Fractional seconds and time zones survive the round trip
+	ts = time.Date(2020, time.January, 2, 15, 4, 5, 123456789, time.FixedZone("CET", 3600))
+	data, err = bertrpc.Encode(bertrpc.T(bertrpc.A("created"), bertrpc.ISOTime(ts)))
+	if err != nil {
+		t.Error(err)
+	}
+	var decoded struct {
+		Tag     string
+		Created bertrpc.ISOTime
+	}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !decoded.Created.Time().Equal(ts) {
+		t.Errorf("incorrect decoded time: %v (!= %v)", decoded.Created.Time(), ts)
+	}
+
+	// <<"yesterday">>
+	var iso bertrpc.ISOTime
+	if err := bertrpc.Decode(bytes.NewBuffer(append([]byte{131, 109, 0, 0, 0, 9}, "yesterday"...)), &iso); err == nil {
+		t.Errorf("decoding an invalid time should fail")
+	}
+}
+
+func TestEncodeTimestamp(t *testing.T) {
+	ts := time.Date(2020, time.October, 14, 14, 20, 0, 123456789, time.UTC)
+	data, err := bertrpc.Encode(ts)
+	if err != nil {
+		t.Error(err)
+	}
+	// {1602, 685200, 123456}
+	expected := []byte{131, 104, 3, 98, 0, 0, 6, 66, 98, 0, 10, 116, 144, 98, 0, 1, 226, 64}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeTimestamp: expected %v, actual %v", expected, data)
+	}
+
+	// Decoding gives back the time, truncated to the microsecond
+	want := ts.Truncate(time.Microsecond)
+	decoded, err := bertrpc.DecodeTimestamp(bytes.NewBuffer(data))
+	if err != nil {
+		t.Errorf("cannot decode timestamp: %s", err)
+	} else if !decoded.Equal(want) || decoded.Location() != time.UTC {
+		t.Errorf("incorrect decoded time: %v (!= %v)", decoded, want)
+	}
+	var event struct {
+		Name string
+		At   time.Time
+	}
+	data, err = bertrpc.Encode(bertrpc.T("started", ts.In(time.FixedZone("CET", 3600))))
+	if err != nil {
+		t.Error(err)
+	}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &event); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if !event.At.Equal(want) {
+		t.Errorf("incorrect decoded time: %v (!= %v)", event.At, want)
+	}
+
+	// Secs and MicroSecs stay positive before the epoch: {-1, 999999, 500000}
+	ts = time.Unix(-1, 500000000)
+	data, err = bertrpc.Encode(ts)
+	if err != nil {
+		t.Error(err)
+	}
+	expected = []byte{131, 104, 3, 98, 255, 255, 255, 255, 98, 0, 15, 66, 63, 98, 0, 7, 161, 32}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeTimestamp: expected %v, actual %v", expected, data)
+	}
+	if decoded, err := bertrpc.DecodeTimestamp(bytes.NewBuffer(data)); err != nil || !decoded.Equal(ts) {
+		t.Errorf("incorrect decoded time: %v (!= %v), %v", decoded, ts, err)
+	}
+
+	// {1602, 2000000, 0}
+	input := []byte{131, 104, 3, 98, 0, 0, 6, 66, 98, 0, 30, 132, 128, 97, 0}
+	if _, err := bertrpc.DecodeTimestamp(bytes.NewBuffer(input)); err == nil {
+		t.Errorf("decoding a timestamp with more than a million seconds should fail")
+	}
+}
+
+func TestUnixMillis(t *testing.T) {
+	ts := time.Date(2020, time.October, 14, 14, 20, 0, 123456789, time.UTC)
+	data, err := bertrpc.Encode(bertrpc.UnixMillis(ts))
+	if err != nil {
+		t.Error(err)
+	}
+	expected, err := bertrpc.Encode(int64(1602685200123))
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeUnixMillis: expected %v, actual %v", expected, data)
+	}
+
+	var decoded bertrpc.UnixMillis
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+	} else if want := ts.Truncate(time.Millisecond); !decoded.Time().Equal(want) {
+		t.Errorf("incorrect decoded time: %v (!= %v)", decoded.Time(), want)
+	}
+}
@@ -0,0 +1,155 @@
+package bertrpc
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// ============================================================================
+// Tagged unions
+
+// Erlang protocols often model variants as tuples starting with a tag atom, like {circle, R}
+// or {rect, W, H}. In Go, the variants are types implementing a common interface.
+var unions = struct {
+	sync.RWMutex
+	// tags gives the tag atom of each registered concrete type.
+	tags map[reflect.Type]string
+	// types gives, for each registered interface, the concrete type of each tag.
+	types map[reflect.Type]map[string]reflect.Type
+}{
+	tags:  make(map[reflect.Type]string),
+	types: make(map[reflect.Type]map[string]reflect.Type),
+}
+
+// RegisterUnion registers the concrete types of a union, with their tag atom. iface is a pointer to the
+// interface they implement, like (*Shape)(nil). Values of registered struct types, or pointers to them,
+// are then encoded as a tuple made of their tag followed by their fields, like any struct.
+// Decoding into the interface reads the tag to select the concrete type, then decodes the fields into it.
+// RegisterUnion panics if iface is not a pointer to an interface, if a type does not implement it,
+// or if two types share a tag.
+func RegisterUnion(iface interface{}, types map[reflect.Type]string) {
+	t := reflect.TypeOf(iface)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+		panic(fmt.Sprintf("bertrpc: RegisterUnion expects a pointer to an interface, not %v", t))
+	}
+	t = t.Elem()
+
+	byTag := make(map[string]reflect.Type, len(types))
+	for concrete, tag := range types {
+		if !concrete.Implements(t) {
+			panic(fmt.Sprintf("bertrpc: %s does not implement %s", concrete, t))
+		}
+		if structType(concrete) == nil {
+			panic(fmt.Sprintf("bertrpc: %s is not a struct or a pointer to a struct", concrete))
+		}
+		if other, ok := byTag[tag]; ok {
+			panic(fmt.Sprintf("bertrpc: %s and %s are both tagged %s", other, concrete, tag))
+		}
+		byTag[tag] = concrete
+	}
+
+	unions.Lock()
+	defer unions.Unlock()
+	unions.types[t] = byTag
+	for concrete, tag := range types {
+		unions.tags[concrete] = tag
+	}
+}
+
+// unionTag returns the tag of a type registered in a union.
+func unionTag(t reflect.Type) (string, bool) {
+	unions.RLock()
+	defer unions.RUnlock()
+	tag, ok := unions.tags[t]
+	return tag, ok
+}
+
+// unionTypes returns the concrete types of a registered interface, by tag.
+func unionTypes(t reflect.Type) (map[string]reflect.Type, bool) {
+	unions.RLock()
+	defer unions.RUnlock()
+	byTag, ok := unions.types[t]
+	return byTag, ok
+}
+
+// structType returns the struct type of a struct or of a pointer to a struct, or nil.
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+// encodeUnion encodes a value of a registered type as {Tag, Fields...}.
+func encodeUnion(buf termWriter, tag string, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return fmt.Errorf("cannot encode nil %s", v.Type())
+		}
+		v = v.Elem()
+	}
+	fields := cachedStructFields(v.Type())
+	if fields.err != nil {
+		return fields.err
+	}
+
+	elems := []interface{}{A(tag)}
+	for _, i := range fields.elems {
+		elems = append(elems, v.Field(i).Interface())
+	}
+	return encodeTuple(buf, Tuple{Elems: elems})
+}
+
+// decodeUnion decodes {Tag, Fields...} into a registered interface, using the concrete type of the tag.
+// A type without fields can also be decoded from its tag alone.
+func decodeUnion(r io.Reader, val reflect.Value, byTag map[string]reflect.Type) error {
+	byte1 := make([]byte, 1)
+	if err := readFull(r, byte1); err != nil {
+		return err
+	}
+
+	var tag string
+	length := 0
+	switch t := int(byte1[0]); t {
+	case TagDeprecatedAtom, TagSmallAtom, TagAtomUTF8, TagSmallAtomUTF8:
+		atom, err := readAtomBody(r, t)
+		if err != nil {
+			return err
+		}
+		tag = atom
+	case TagSmallTuple, TagLargeTuple:
+		arity, err := readTupleArity(r, t)
+		if err != nil {
+			return err
+		}
+		if arity == 0 {
+			return fmt.Errorf("cannot decode empty tuple to %s", val.Type())
+		}
+		if tag, err = readAtom(r); err != nil {
+			return err
+		}
+		length = arity - 1
+	default:
+		return TagError{Expected: TagSmallTuple, Got: t}
+	}
+
+	concrete, ok := byTag[tag]
+	if !ok {
+		return fmt.Errorf("unknown tag %s for %s", tag, val.Type())
+	}
+	v := reflect.New(structType(concrete))
+	if err := decodeStructElts(r, length, v.Elem()); err != nil {
+		return err
+	}
+	if concrete.Kind() == reflect.Ptr {
+		val.Set(v)
+	} else {
+		val.Set(v.Elem())
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package bertrpc_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bruceluk/go-erlang/bertrpc"
+)
+
+type shape interface {
+	area() float64
+}
+
+type circle struct {
+	R float64
+}
+
+func (c circle) area() float64 { return 3 * c.R * c.R }
+
+type rect struct {
+	W, H int
+}
+
+func (r *rect) area() float64 { return float64(r.W * r.H) }
+
+// layer is a variant of another union, holding a list of shapes.
+type layer struct {
+	Name   string
+	Shapes []shape
+}
+
+type element interface {
+	shapes() []shape
+}
+
+func (l layer) shapes() []shape { return l.Shapes }
+
+func init() {
+	bertrpc.RegisterUnion((*shape)(nil), map[reflect.Type]string{
+		reflect.TypeOf(circle{}): "circle",
+		reflect.TypeOf(&rect{}):  "rect",
+	})
+	bertrpc.RegisterUnion((*element)(nil), map[reflect.Type]string{
+		reflect.TypeOf(layer{}): "layer",
+	})
+}
+
+// Registered types are encoded as tuples starting with their tag.
+func TestEncodeUnion(t *testing.T) {
+	shapes := []shape{circle{R: 1.5}, &rect{W: 2, H: 3}}
+
+	data, err := bertrpc.Encode(shapes)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// [{circle, 1.5}, {rect, 2, 3}]
+	expected := []byte{131, 108, 0, 0, 0, 2,
+		104, 2, 119, 6, 99, 105, 114, 99, 108, 101, 70, 63, 248, 0, 0, 0, 0, 0, 0,
+		104, 3, 119, 4, 114, 101, 99, 116, 97, 2, 97, 3, 106}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncodeUnion: expected %v, actual %v", expected, data)
+	}
+
+	var decoded interface{}
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	want := bertrpc.L(bertrpc.T(bertrpc.Atom{Value: "circle"}, 1.5), bertrpc.T(bertrpc.Atom{Value: "rect"}, int64(2), int64(3)))
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, want)
+	}
+}
+
+func TestRegisterUnionPanics(t *testing.T) {
+	tests := []struct {
+		name  string
+		iface interface{}
+		types map[reflect.Type]string
+	}{
+		{name: "not a pointer to an interface", iface: shape(nil), types: nil},
+		{name: "does not implement", iface: (*shape)(nil), types: map[reflect.Type]string{reflect.TypeOf(rect{}): "rect"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(st *testing.T) {
+			defer func() {
+				if recover() == nil {
+					st.Errorf("RegisterUnion should panic")
+				}
+			}()
+			bertrpc.RegisterUnion(tc.iface, tc.types)
+		})
+	}
+}
+
+// The tag selects the concrete type decoded into the interface.
+func TestDecodeUnion(t *testing.T) {
+	var s shape
+	// {circle, 1.5}
+	input := []byte{131, 104, 2, 119, 6, 99, 105, 114, 99, 108, 101, 70, 63, 248, 0, 0, 0, 0, 0, 0}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &s); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if s != (circle{R: 1.5}) {
+		t.Errorf("incorrect decoded value: %#v", s)
+	}
+
+	// {rect, 2, 3}
+	input = []byte{131, 104, 3, 119, 4, 114, 101, 99, 116, 97, 2, 97, 3}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &s); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if r, ok := s.(*rect); !ok || *r != (rect{W: 2, H: 3}) {
+		t.Errorf("incorrect decoded value: %#v", s)
+	}
+
+	// Round trip
+	shapes := []shape{&rect{W: 4, H: 5}, circle{R: 2}}
+	data, err := bertrpc.Encode(shapes)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var decoded []shape
+	if err := bertrpc.Decode(bytes.NewBuffer(data), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(decoded, shapes) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, shapes)
+	}
+
+	// {triangle, 1, 2, 3}
+	input = []byte{131, 104, 4, 119, 8, 116, 114, 105, 97, 110, 103, 108, 101, 97, 1, 97, 2, 97, 3}
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &s); err == nil {
+		t.Errorf("decoding an unknown tag should fail")
+	}
+}
+
+// Registries apply at every depth: here, to shapes in a list, in a struct, in a union, in a list.
+func TestDecodeNestedUnion(t *testing.T) {
+	// [{layer, <<"top">>, [{circle, 1.5}, {rect, 2, 3}]}]
+	input := []byte{131, 108, 0, 0, 0, 1,
+		104, 3, 119, 5, 108, 97, 121, 101, 114, 109, 0, 0, 0, 3, 116, 111, 112,
+		108, 0, 0, 0, 2,
+		104, 2, 119, 6, 99, 105, 114, 99, 108, 101, 70, 63, 248, 0, 0, 0, 0, 0, 0,
+		104, 3, 119, 4, 114, 101, 99, 116, 97, 2, 97, 3, 106,
+		106}
+	want := []element{layer{Name: "top", Shapes: []shape{circle{R: 1.5}, &rect{W: 2, H: 3}}}}
+
+	var decoded []element
+	if err := bertrpc.Decode(bytes.NewBuffer(input), &decoded); err != nil {
+		t.Errorf("cannot decode Erlang term: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("incorrect decoded value: %#v (!= %#v)", decoded, want)
+	}
+
+	data, err := bertrpc.Encode(want)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(data, input) {
+		t.Errorf("EncodeNestedUnion: expected %v, actual %v", input, data)
+	}
+}